@@ -7,16 +7,25 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
+	"golang.org/x/term"
 
 	"go.xrstf.de/protokol/pkg/collector"
+	"go.xrstf.de/protokol/pkg/control"
+	"go.xrstf.de/protokol/pkg/trigger"
 	"go.xrstf.de/protokol/pkg/watcher"
 
 	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -58,21 +67,67 @@ type options struct {
 	containerNames []string
 	stream         bool
 	streamPrefix   string
+	noColor        bool
+	grep           []string
+	grepExclude    []string
+	grepBefore     int
+	grepAfter      int
+	grepTarget     string
+	redact         bool
+	pretty         bool
+	prettyFields   []string
+	structuredLogs bool
+	redactPatterns []string
+	triggers       string
 	labels         string
 	live           bool
 	oneShot        bool
 	flatFiles      bool
 	dumpMetadata   bool
+	podHistory     bool
+	dumpTimeline   bool
 	dumpEvents     bool
 	dumpRawEvents  bool
+	eventKinds     []string
+	collapseEvents bool
+	watchNodes     bool
+	nodeLogs       []string
+	controlSocket  string
+	mark           string
+	stopTerminated bool
+	stopCompletion string
+	stopIdle       time.Duration
+	timeout        time.Duration
+	idleTimeout    time.Duration
+	failOnCrash    bool
+	maxRestarts    int
+	incidents      bool
+	incidentLines  int
+	bufferLines    int
+	bufferPolicy   string
 	verbose        bool
 	version        bool
 }
 
 func main() {
-	rootCtx := context.Background()
+	// stop gracefully on the first signal, so that the collectors can finish
+	// their work; a second signal terminates protokol immediately
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	go func() {
+		<-signalCtx.Done()
+		stopSignals()
+	}()
+
+	rootCtx := signalCtx
+
 	opt := options{
-		streamPrefix: "[%pN/%pn:%c] >>",
+		streamPrefix:  "[%pN/%pn:%c] >>",
+		grepTarget:    "stream",
+		incidentLines: 100,
+		bufferLines:   10000,
+		bufferPolicy:  string(watcher.BufferBlock),
 	}
 
 	pflag.StringVar(&opt.kubeconfig, "kubeconfig", opt.kubeconfig, "kubeconfig file to use (uses $KUBECONFIG by default)")
@@ -83,11 +138,42 @@ func main() {
 	pflag.BoolVarP(&opt.flatFiles, "flat", "f", opt.flatFiles, "Do not create directory per namespace, but put all logs in the same directory")
 	pflag.BoolVar(&opt.live, "live", opt.live, "Only consider running pods, ignore completed/failed pods")
 	pflag.BoolVar(&opt.stream, "stream", opt.stream, "Do not just dump logs to disk, but also stream them to stdout")
-	pflag.StringVar(&opt.streamPrefix, "prefix", opt.streamPrefix, "Prefix pattern to put at the beginning of each streamed line (pn = Pod name, pN = Pod namespace, c = container name)")
+	pflag.StringVar(&opt.streamPrefix, "prefix", opt.streamPrefix, "Prefix pattern to put at the beginning of each streamed line (pn = Pod name, pN = Pod namespace, c = container name), or a Go template (see README)")
+	pflag.BoolVar(&opt.noColor, "no-color", opt.noColor, "Do not colorize streamed lines (colors are only used if stdout is a terminal)")
+	pflag.BoolVar(&opt.pretty, "pretty", opt.pretty, "Render streamed JSON and logfmt lines as 'LEVEL message key=value ...'")
+	pflag.StringArrayVar(&opt.prettyFields, "pretty-field", opt.prettyFields, "Only include this field besides level and message in pretty lines (implies --pretty) (can be given multiple times)")
+	pflag.BoolVar(&opt.structuredLogs, "structured-logs", opt.structuredLogs, "Additionally store each log line as a JSON object with its parsed JSON/logfmt fields in a .jsonl file")
+	pflag.StringArrayVar(&opt.grep, "grep", opt.grep, "Only keep log lines matching this regular expression (can be given multiple times)")
+	pflag.StringArrayVar(&opt.grepExclude, "grep-exclude", opt.grepExclude, "Drop log lines matching this regular expression (can be given multiple times)")
+	pflag.IntVar(&opt.grepBefore, "grep-before", opt.grepBefore, "Number of lines to keep before each line matching --grep")
+	pflag.IntVar(&opt.grepAfter, "grep-after", opt.grepAfter, "Number of lines to keep after each line matching --grep")
+	pflag.StringVar(&opt.grepTarget, "grep-target", opt.grepTarget, "Where to apply --grep and --grep-exclude: stream, disk or all")
 	pflag.BoolVar(&opt.oneShot, "oneshot", opt.oneShot, "Dump logs, but do not tail the containers (i.e. exit after downloading the current state)")
-	pflag.BoolVar(&opt.dumpMetadata, "metadata", opt.dumpMetadata, "Dump Pods additionally as YAML (note that this can include secrets in environment variables)")
-	pflag.BoolVar(&opt.dumpEvents, "events", opt.dumpEvents, "Dump events for each matching Pod as a human readable log file (note: label selectors are not respected)")
-	pflag.BoolVar(&opt.dumpRawEvents, "events-raw", opt.dumpRawEvents, "Dump events for each matching Pod as YAML (note: label selectors are not respected)")
+	pflag.BoolVar(&opt.stopTerminated, "stop-when-terminated", opt.stopTerminated, "Stop once all matching pods have terminated (succeeded, failed or were deleted)")
+	pflag.StringVar(&opt.stopCompletion, "stop-on-completion", opt.stopCompletion, "Stop once a matching pod with these labels (label selector) has succeeded or failed")
+	pflag.DurationVar(&opt.stopIdle, "stop-when-idle", opt.stopIdle, "Stop once there were no active matching pods for this long (e.g. 30s)")
+	pflag.DurationVar(&opt.timeout, "timeout", opt.timeout, "Maximum duration to run before stopping (e.g. 1h)")
+	pflag.DurationVar(&opt.idleTimeout, "idle-timeout", opt.idleTimeout, "Stop if no log output and no pod changes or events were received for this long (e.g. 5m)")
+	pflag.BoolVar(&opt.failOnCrash, "fail-on-crash", opt.failOnCrash, "Exit with a non-zero code if any matching container exited with an error, was OOMKilled or restarted too often")
+	pflag.IntVar(&opt.maxRestarts, "max-restarts", opt.maxRestarts, "Number of restarts per container that are tolerated with --fail-on-crash")
+	pflag.BoolVar(&opt.incidents, "incidents", opt.incidents, "Whenever a matching container restarts or exits with an error, store its last log lines, the Pod, its events and its Node's conditions in incidents/")
+	pflag.IntVar(&opt.incidentLines, "incident-lines", opt.incidentLines, "Number of log lines to store for each incident")
+	pflag.BoolVar(&opt.dumpMetadata, "metadata", opt.dumpMetadata, "Dump Pods additionally as YAML (note that this can include secrets in environment variables, see --redact)")
+	pflag.BoolVar(&opt.podHistory, "metadata-history", opt.podHistory, "Dump every revision of each Pod into a multi-document YAML file (implies --metadata)")
+	pflag.BoolVar(&opt.redact, "redact", opt.redact, "Mask secrets (like bearer tokens, AWS keys and passwords) in logs and sensitive environment variables in Pod metadata")
+	pflag.StringArrayVar(&opt.redactPatterns, "redact-pattern", opt.redactPatterns, "Additional regular expression to mask in logs and Pod metadata; if it has a group named 'secret', only this group is masked (implies --redact) (can be given multiple times)")
+	pflag.StringVar(&opt.triggers, "triggers", opt.triggers, "YAML file with triggers that execute actions (commands, webhooks, marker files or snapshots) when a log line matches (see README)")
+	pflag.BoolVar(&opt.dumpTimeline, "timeline", opt.dumpTimeline, "Record lifecycle transitions (conditions, container states, restarts, deletion) of each matching Pod")
+	pflag.BoolVar(&opt.dumpEvents, "events", opt.dumpEvents, "Dump events for each matching Pod as a human readable log file")
+	pflag.BoolVar(&opt.dumpRawEvents, "events-raw", opt.dumpRawEvents, "Dump events for each matching Pod as YAML")
+	pflag.BoolVar(&opt.watchNodes, "nodes", opt.watchNodes, "Record condition changes, taints and events of the Nodes hosting matching Pods")
+	pflag.StringArrayVar(&opt.nodeLogs, "node-log", opt.nodeLogs, "When stopping, fetch this log (a service like kubelet or a file in /var/log) from the Nodes hosting matching Pods (requires the NodeLogQuery feature) (can be given multiple times)")
+	pflag.IntVar(&opt.bufferLines, "buffer-lines", opt.bufferLines, "Number of log lines to buffer per container, so that slow outputs do not slow down the log streams (0 disables buffering)")
+	pflag.StringVar(&opt.bufferPolicy, "buffer-policy", opt.bufferPolicy, "What to do when the buffer of a container is full: block (pause the log stream), drop-oldest (discard old lines) or spill (write lines into a temporary file)")
+	pflag.StringVar(&opt.controlSocket, "control-socket", opt.controlSocket, "Unix socket to listen on for control commands (e.g. to start new log segments)")
+	pflag.StringVar(&opt.mark, "mark", opt.mark, "Tell the protokol listening on --control-socket to start a new log segment with the given name, then exit")
+	pflag.StringArrayVar(&opt.eventKinds, "event-kind", opt.eventKinds, "Also dump events for objects of this kind, e.g. Deployment (supports glob expression) (can be given multiple times)")
+	pflag.BoolVar(&opt.collapseEvents, "collapse-events", opt.collapseEvents, "Write only the final version of each event (with its final count) into the human readable event log when protokol stops")
 	pflag.BoolVarP(&opt.verbose, "verbose", "v", opt.verbose, "Enable more verbose output")
 	pflag.BoolVarP(&opt.version, "version", "V", opt.version, "Show version info and exit immediately")
 	pflag.Parse()
@@ -110,6 +196,23 @@ func main() {
 		log.SetLevel(logrus.DebugLevel)
 	}
 
+	// //////////////////////////////////////
+	// send control commands to another protokol
+
+	if opt.mark != "" {
+		if opt.controlSocket == "" {
+			log.Fatal("--mark requires --control-socket.")
+		}
+
+		segment, err := control.Mark(rootCtx, opt.controlSocket, opt.mark)
+		if err != nil {
+			log.Fatalf("Failed to start new segment: %v", err)
+		}
+
+		log.WithField("segment", segment).Info("Started new segment.")
+		return
+	}
+
 	// //////////////////////////////////////
 	// validate CLI flags
 
@@ -125,6 +228,69 @@ func main() {
 		}
 	}
 
+	stopConditions := watcher.StopConditions{
+		AllTerminated: opt.stopTerminated,
+		Idle:          opt.stopIdle,
+	}
+
+	if opt.stopCompletion != "" {
+		var err error
+		if stopConditions.Completion, err = labels.Parse(opt.stopCompletion); err != nil {
+			log.Fatalf("Invalid --stop-on-completion label selector: %v", err)
+		}
+	}
+
+	if len(opt.eventKinds) > 0 && !opt.dumpEvents && !opt.dumpRawEvents {
+		log.Fatal("--event-kind requires --events or --events-raw.")
+	}
+
+	if opt.collapseEvents && !opt.dumpEvents {
+		log.Fatal("--collapse-events requires --events.")
+	}
+
+	if opt.maxRestarts < 0 {
+		log.Fatal("--max-restarts must not be negative.")
+	}
+
+	if opt.incidentLines <= 0 {
+		log.Fatal("--incident-lines must be positive.")
+	}
+
+	if opt.timeout < 0 || opt.idleTimeout < 0 {
+		log.Fatal("Timeouts must not be negative.")
+	}
+
+	switch watcher.BufferPolicy(opt.bufferPolicy) {
+	case watcher.BufferBlock, watcher.BufferDropOldest, watcher.BufferSpill:
+	default:
+		log.Fatal("--buffer-policy must be one of block, drop-oldest or spill.")
+	}
+
+	if opt.bufferLines < 0 {
+		log.Fatal("--buffer-lines must not be negative.")
+	}
+
+	if opt.grepTarget != "stream" && opt.grepTarget != "disk" && opt.grepTarget != "all" {
+		log.Fatal("--grep-target must be one of stream, disk or all.")
+	}
+
+	if opt.grepBefore < 0 || opt.grepAfter < 0 {
+		log.Fatal("--grep-before and --grep-after must not be negative.")
+	}
+
+	if (opt.pretty || len(opt.prettyFields) > 0) && !opt.stream {
+		log.Fatal("--pretty requires --stream.")
+	}
+
+	filterLogs := len(opt.grep) > 0 || len(opt.grepExclude) > 0
+	if filterLogs && opt.grepTarget == "stream" && !opt.stream {
+		log.Fatal("Filtering streamed logs requires --stream (use --grep-target to filter the logs on disk).")
+	}
+
+	if opt.oneShot && (stopConditions.AllTerminated || stopConditions.Completion != nil || stopConditions.Idle > 0) {
+		log.Fatal("Stop conditions cannot be combined with --oneshot.")
+	}
+
 	args := pflag.Args()
 
 	hasNames := len(args) > 0
@@ -136,51 +302,148 @@ func main() {
 		log.Fatal("At least a namespace or a resource name pattern must be given.")
 	}
 
+	// //////////////////////////////////////
+	// setup kubernetes client
+
+	log.Debug("Creating Kubernetes clientset…")
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opt.kubeconfig
+
+	deferred := clientcmd.NewInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}, os.Stdin)
+	config, err := deferred.ClientConfig()
+	if err != nil {
+		log.Fatalf("Failed to create Kubernetes client: %v", err)
+	}
+
+	clusterName := getClusterName(deferred)
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Failed to create Kubernetes clientset: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		log.Fatalf("Failed to create dynamic Kubernetes client: %v", err)
+	}
+
+	// //////////////////////////////////////
+	// setup collectors
+
 	if opt.directory == "" {
 		opt.directory = fmt.Sprintf("protokol-%s", time.Now().Format("2006.01.02T15.04.05"))
 	}
 
 	log.WithField("directory", opt.directory).Info("Storing logs on disk.")
 
-	coll, err := collector.NewDiskCollector(opt.directory, opt.flatFiles, opt.dumpEvents, opt.dumpRawEvents)
+	var segments *collector.Segments
+	if opt.controlSocket != "" {
+		segments = collector.NewSegments()
+	}
+
+	coll, err := collector.NewDiskCollector(opt.directory, collector.DiskOptions{
+		FlatFiles:       opt.flatFiles,
+		EventsAsText:    opt.dumpEvents,
+		RawEvents:       opt.dumpRawEvents,
+		CollapseEvents:  opt.collapseEvents,
+		MetadataHistory: opt.podHistory,
+		StructuredLogs:  opt.structuredLogs,
+		Segments:        segments,
+	})
 	if err != nil {
 		log.Fatalf("Failed to create log collector: %v", err)
 	}
 
+	filterOpts := collector.FilterOptions{
+		Include: opt.grep,
+		Exclude: opt.grepExclude,
+		Before:  opt.grepBefore,
+		After:   opt.grepAfter,
+	}
+
+	if filterLogs && opt.grepTarget != "stream" {
+		coll, err = collector.NewFilterCollector(coll, filterOpts)
+		if err != nil {
+			log.Fatalf("Failed to create log filter: %v", err)
+		}
+	}
+
 	if opt.stream {
-		stdoutCollector, err := collector.NewStreamCollector(opt.streamPrefix)
+		stdoutCollector, err := collector.NewStreamCollector(collector.StreamOptions{
+			Prefix:       opt.streamPrefix,
+			Cluster:      clusterName,
+			Colors:       !opt.noColor && term.IsTerminal(int(os.Stdout.Fd())),
+			Highlight:    opt.grep,
+			Pretty:       opt.pretty,
+			PrettyFields: opt.prettyFields,
+		})
 		if err != nil {
 			log.Fatalf("Failed to create log collector: %v", err)
 		}
 
+		if filterLogs && opt.grepTarget != "disk" {
+			stdoutCollector, err = collector.NewFilterCollector(stdoutCollector, filterOpts)
+			if err != nil {
+				log.Fatalf("Failed to create log filter: %v", err)
+			}
+		}
+
 		coll, err = collector.NewMultiplexCollector(coll, stdoutCollector)
 		if err != nil {
 			log.Fatalf("Failed to create log collector: %v", err)
 		}
 	}
 
-	// //////////////////////////////////////
-	// setup kubernetes client
+	var redactOpts *collector.RedactOptions
+	if opt.redact || len(opt.redactPatterns) > 0 {
+		redactOpts = &collector.RedactOptions{
+			Patterns: append(collector.DefaultRedactionPatterns, opt.redactPatterns...),
+		}
+	}
 
-	log.Debug("Creating Kubernetes clientset…")
+	if opt.triggers != "" {
+		triggers, err := trigger.LoadConfig(opt.triggers)
+		if err != nil {
+			log.Fatalf("Failed to load triggers: %v", err)
+		}
 
-	rules := clientcmd.NewDefaultClientConfigLoadingRules()
-	rules.ExplicitPath = opt.kubeconfig
+		triggerOpts := trigger.Options{
+			Snapshotter:       trigger.NewSnapshotter(clientset),
+			SnapshotDirectory: filepath.Join(opt.directory, "snapshots"),
+		}
 
-	deferred := clientcmd.NewInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}, os.Stdin)
-	config, err := deferred.ClientConfig()
-	if err != nil {
-		log.Fatalf("Failed to create Kubernetes client: %v", err)
+		// snapshots do not pass through the redaction collector
+		if redactOpts != nil {
+			triggerOpts.Redactor, err = collector.NewRedactor(*redactOpts)
+			if err != nil {
+				log.Fatalf("Failed to create redaction: %v", err)
+			}
+		}
+
+		coll = trigger.NewCollector(coll, triggers, triggerOpts)
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("Failed to create Kubernetes clientset: %v", err)
+	// redaction must happen before any data reaches any of the collectors
+	if redactOpts != nil {
+		coll, err = collector.NewRedactCollector(coll, *redactOpts)
+		if err != nil {
+			log.Fatalf("Failed to create redaction: %v", err)
+		}
 	}
 
-	dynamicClient, err := dynamic.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("Failed to create dynamic Kubernetes client: %v", err)
+	if segments != nil {
+		if err := control.NewServer(opt.controlSocket, segments, log).Listen(rootCtx); err != nil {
+			log.Fatalf("Failed to start control server: %v", err)
+		}
+
+		log.WithField("socket", opt.controlSocket).Info("Listening for control commands.")
+	}
+
+	if opt.timeout > 0 {
+		var cancel context.CancelFunc
+		rootCtx, cancel = context.WithTimeoutCause(rootCtx, opt.timeout, fmt.Errorf("timeout of %v reached", opt.timeout))
+		defer cancel()
 	}
 
 	// //////////////////////////////////////
@@ -191,11 +454,6 @@ func main() {
 		Resource: "pods",
 	})
 
-	eventResourceInterface := dynamicClient.Resource(schema.GroupVersionResource{
-		Version:  "v1",
-		Resource: "events",
-	})
-
 	if opt.dumpEvents || opt.dumpRawEvents {
 		log.Debug("Starting to watch pods & events…")
 	} else {
@@ -206,22 +464,49 @@ func main() {
 	// is not supported, so we need a real revision; to achieve this we simply create
 	// a "standard" watcher, takes the first event and its resourceVersion as the
 	// starting point for the second, longlived retrying watcher
-	initialPods, resourceVersion, err := getStartPods(rootCtx, clientset, opt.labels)
+	podLabels := opt.labels
+
+	// to apply the label selector to events, the watcher needs to know the labels
+	// of all pods, not just the matching ones (it filters the pods itself)
+	if opt.dumpEvents || opt.dumpRawEvents {
+		podLabels = ""
+	}
+
+	initialPods, resourceVersion, err := getStartPods(rootCtx, clientset, podLabels)
 	if err != nil {
 		log.Fatalf("Failed to determine initial resourceVersion: %v", err)
 	}
 
-	var initialEvents []corev1.Event
+	var (
+		initialEvents []corev1.Event
+		eventsGVR     schema.GroupVersionResource
+	)
+
 	if opt.dumpEvents || opt.dumpRawEvents {
-		initialEvents, err = getStartEvents(rootCtx, clientset, opt.labels)
+		initialEvents, eventsGVR, err = getStartEvents(rootCtx, clientset, opt.labels)
 		if err != nil {
 			log.Fatalf("Failed to retrieve initial events: %v", err)
 		}
+
+		log.WithField("api", eventsGVR.GroupVersion().String()).Debug("Using events API.")
+	}
+
+	var (
+		initialNodes        []corev1.Node
+		nodeResourceVersion string
+	)
+
+	if opt.watchNodes {
+		initialNodes, nodeResourceVersion, err = getStartNodes(rootCtx, clientset)
+		if err != nil {
+			log.Fatalf("Failed to retrieve initial nodes: %v", err)
+		}
 	}
 
 	var (
 		podWatcher   watch.Interface
 		eventWatcher watch.Interface
+		nodeWatcher  watch.Interface
 	)
 
 	if !opt.oneShot {
@@ -233,12 +518,27 @@ func main() {
 			log.Fatalf("Failed to create watch for pods: %v", err)
 		}
 
-		eventWatcher, err = watchtools.NewRetryWatcher(resourceVersion, &watchContextInjector{
-			ctx: rootCtx,
-			ri:  eventResourceInterface,
-		})
-		if err != nil {
-			log.Fatalf("Failed to create watch for events: %v", err)
+		if opt.dumpEvents || opt.dumpRawEvents {
+			eventWatcher, err = watchtools.NewRetryWatcher(resourceVersion, &watchContextInjector{
+				ctx: rootCtx,
+				ri:  dynamicClient.Resource(eventsGVR),
+			})
+			if err != nil {
+				log.Fatalf("Failed to create watch for events: %v", err)
+			}
+		}
+
+		if opt.watchNodes {
+			nodeWatcher, err = watchtools.NewRetryWatcher(nodeResourceVersion, &watchContextInjector{
+				ctx: rootCtx,
+				ri: dynamicClient.Resource(schema.GroupVersionResource{
+					Version:  "v1",
+					Resource: "nodes",
+				}),
+			})
+			if err != nil {
+				log.Fatalf("Failed to create watch for nodes: %v", err)
+			}
 		}
 	}
 
@@ -249,15 +549,74 @@ func main() {
 		ContainerNames: opt.containerNames,
 		RunningOnly:    opt.live,
 		OneShot:        opt.oneShot,
-		DumpMetadata:   opt.dumpMetadata,
+		DumpMetadata:   opt.dumpMetadata || opt.podHistory,
 		DumpEvents:     opt.dumpEvents || opt.dumpRawEvents,
+		DumpTimeline:   opt.dumpTimeline,
+		EventKinds:     opt.eventKinds,
+		StopConditions: stopConditions,
+		IdleTimeout:    opt.idleTimeout,
+		DetectFailures: opt.failOnCrash,
+		MaxRestarts:    opt.maxRestarts,
+		IncidentLines:  opt.incidentLines,
+		WatchNodes:     opt.watchNodes,
+		NodeLogs:       opt.nodeLogs,
+		Buffer: watcher.BufferOptions{
+			Lines:  opt.bufferLines,
+			Policy: watcher.BufferPolicy(opt.bufferPolicy),
+		},
+	}
+
+	if opt.incidents {
+		watcherOpts.Incidents = watcher.NewIncidentSource(clientset)
+	}
+
+	w := watcher.NewWatcher(watcher.NewLogStreamer(clientset), coll, log, initialPods, initialEvents, initialNodes, watcherOpts)
+
+	result := w.Watch(rootCtx, podWatcher, eventWatcher, nodeWatcher)
+
+	if err := coll.Close(); err != nil {
+		log.WithError(err).Error("Failed to close log collector.")
+	}
+
+	if result.DroppedLines > 0 {
+		log.WithField("lines", result.DroppedLines).Warn("Log lines were dropped because the outputs could not keep up, consider --buffer-policy=spill.")
+	}
+
+	failed := false
+
+	if result.PodsFailed {
+		log.WithField("reason", result.StopReason).Error("Watched pods have failed.")
+		failed = true
+	}
+
+	if len(result.Failures) > 0 {
+		log.WithField("containers", len(result.Failures)).Error("Containers have crashed.")
+		printFailures(result.Failures)
+		failed = true
 	}
 
-	w := watcher.NewWatcher(clientset, coll, log, initialPods, initialEvents, watcherOpts)
-	w.Watch(rootCtx, podWatcher, eventWatcher)
+	if failed {
+		os.Exit(1)
+	}
 }
 
-func getStartPods(ctx context.Context, cs *kubernetes.Clientset, labelSelector string) ([]corev1.Pod, string, error) {
+func printFailures(failures []watcher.ContainerFailure) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tPOD\tCONTAINER\tEXIT CODE\tREASON\tRESTARTS")
+
+	for _, f := range failures {
+		reason := f.Reason
+		if reason == "" {
+			reason = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%d\n", f.Namespace, f.Pod, f.Container, f.ExitCode, reason, f.Restarts)
+	}
+
+	tw.Flush()
+}
+
+func getStartPods(ctx context.Context, cs kubernetes.Interface, labelSelector string) ([]corev1.Pod, string, error) {
 	pods, err := cs.CoreV1().Pods("").List(ctx, metav1.ListOptions{
 		LabelSelector: labelSelector,
 	})
@@ -268,13 +627,56 @@ func getStartPods(ctx context.Context, cs *kubernetes.Clientset, labelSelector s
 	return pods.Items, pods.ResourceVersion, nil
 }
 
-func getStartEvents(ctx context.Context, cs *kubernetes.Clientset, labelSelector string) ([]corev1.Event, error) {
-	events, err := cs.CoreV1().Events("").List(ctx, metav1.ListOptions{})
+// getStartEvents lists all events using the events.k8s.io/v1 API, falling
+// back to the core/v1 API on clusters that do not serve the former. The
+// resource of the API that was used is returned, so the same API can be used
+// to watch for new events.
+func getStartEvents(ctx context.Context, cs kubernetes.Interface, labelSelector string) ([]corev1.Event, schema.GroupVersionResource, error) {
+	newEvents, err := cs.EventsV1().Events("").List(ctx, metav1.ListOptions{})
+	if err == nil {
+		events := make([]corev1.Event, 0, len(newEvents.Items))
+		for i := range newEvents.Items {
+			events = append(events, *watcher.EventFromEventsV1(&newEvents.Items[i]))
+		}
+
+		return events, eventsv1.SchemeGroupVersion.WithResource("events"), nil
+	}
+
+	if !apierrors.IsNotFound(err) {
+		return nil, schema.GroupVersionResource{}, fmt.Errorf("failed to perform list on Events: %w", err)
+	}
+
+	legacyEvents, err := cs.CoreV1().Events("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, schema.GroupVersionResource{}, fmt.Errorf("failed to perform list on Events: %w", err)
+	}
+
+	return legacyEvents.Items, corev1.SchemeGroupVersion.WithResource("events"), nil
+}
+
+// getClusterName returns the name of the cluster of the current kubeconfig
+// context, or an empty string if it cannot be determined.
+func getClusterName(clientConfig clientcmd.ClientConfig) string {
+	rawConfig, err := clientConfig.RawConfig()
+	if err != nil {
+		return ""
+	}
+
+	kubeContext, exists := rawConfig.Contexts[rawConfig.CurrentContext]
+	if !exists {
+		return ""
+	}
+
+	return kubeContext.Cluster
+}
+
+func getStartNodes(ctx context.Context, cs kubernetes.Interface) ([]corev1.Node, string, error) {
+	nodes, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to perform list on Events: %w", err)
+		return nil, "", fmt.Errorf("failed to perform list on Nodes: %w", err)
 	}
 
-	return events.Items, nil
+	return nodes.Items, nodes.ResourceVersion, nil
 }
 
 type watchContextInjector struct {
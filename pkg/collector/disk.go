@@ -4,30 +4,114 @@
 package collector
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/yaml"
 )
 
 type diskCollector struct {
-	directory    string
-	flatFiles    bool
-	eventsAsText bool
-	rawEvents    bool
+	directory       string
+	flatFiles       bool
+	eventsAsText    bool
+	rawEvents       bool
+	metadataHistory bool
+	structuredLogs  bool
+	segments        *Segments
+
+	namesLock sync.Mutex
+	// podGenerations maps pod UIDs to the generation of their name.
+	podGenerations map[types.UID]int
+	// nameGenerations counts how many different pods were seen with the
+	// same namespace and name.
+	nameGenerations map[string]int
+	// deletedPods remembers when pods were deleted, so that they can be
+	// forgotten once no more data is expected for them.
+	deletedPods map[types.UID]time.Time
+	// lastPodExpiry is when deleted pods were last forgotten.
+	lastPodExpiry time.Time
+
+	collapseEvents bool
+
+	eventsLock sync.Mutex
+	// eventVersions remembers the last resourceVersion of each event, so
+	// that replays of the same event are not written twice.
+	eventVersions map[types.UID]eventVersion
+	// collapsedEvents holds the latest version of each event until the
+	// collector is closed or the event expires (only if collapseEvents is
+	// enabled).
+	collapsedEvents []*collapsedEvent
+	collapsedIndex  map[types.UID]*collapsedEvent
+	// lastExpiry is when expired events were last removed.
+	lastExpiry time.Time
+	now        func() time.Time
+
+	historyLock sync.Mutex
+	// podRevisions remembers the last revision written to the history for
+	// each pod (keyed by the history file name).
+	podRevisions map[string]podRevision
+}
+
+type eventVersion struct {
+	resourceVersion string
+	seen            time.Time
+}
+
+type collapsedEvent struct {
+	directory string
+	event     *corev1.Event
+	seen      time.Time
+}
+
+type podRevision struct {
+	uid             types.UID
+	resourceVersion string
+	checksum        [sha256.Size]byte
 }
 
 var _ Collector = &diskCollector{}
 
-func NewDiskCollector(directory string, flatFiles bool, eventsAsText bool, rawEvents bool) (Collector, error) {
+type DiskOptions struct {
+	// FlatFiles disables creating one directory per namespace.
+	FlatFiles bool
+	// EventsAsText enables dumping events into human readable log files.
+	EventsAsText bool
+	// RawEvents enables dumping events as YAML.
+	RawEvents bool
+	// CollapseEvents makes the collector write only the final version of
+	// each event into the human readable event log, once the collector is
+	// closed or the event has not been updated for an hour. Otherwise
+	// every update (e.g. an increased count) of an event results in a new
+	// line.
+	CollapseEvents bool
+	// MetadataHistory enables writing every meaningful revision of a pod
+	// into a multi-document YAML file.
+	MetadataHistory bool
+	// StructuredLogs enables writing each log line additionally as a JSON
+	// object into a .jsonl file. JSON and logfmt lines are parsed into their
+	// fields, all other lines are stored as raw strings.
+	StructuredLogs bool
+	// Segments is optional; if given, all files are written into a
+	// subdirectory named after the current segment.
+	Segments *Segments
+}
+
+func NewDiskCollector(directory string, opt DiskOptions) (Collector, error) {
 	err := os.MkdirAll(directory, 0755)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create directory %q: %w", directory, err)
@@ -39,18 +123,33 @@ func NewDiskCollector(directory string, flatFiles bool, eventsAsText bool, rawEv
 	}
 
 	return &diskCollector{
-		directory:    abs,
-		flatFiles:    flatFiles,
-		eventsAsText: eventsAsText,
-		rawEvents:    rawEvents,
+		directory:       abs,
+		flatFiles:       opt.FlatFiles,
+		eventsAsText:    opt.EventsAsText,
+		rawEvents:       opt.RawEvents,
+		metadataHistory: opt.MetadataHistory,
+		segments:        opt.Segments,
+		structuredLogs:  opt.StructuredLogs,
+		podGenerations:  map[types.UID]int{},
+		nameGenerations: map[string]int{},
+		deletedPods:     map[types.UID]time.Time{},
+		podRevisions:    map[string]podRevision{},
+		collapseEvents:  opt.CollapseEvents,
+		eventVersions:   map[types.UID]eventVersion{},
+		collapsedIndex:  map[types.UID]*collapsedEvent{},
+		now:             time.Now,
 	}, nil
 }
 
 func (c *diskCollector) getDirectory(namespace string) (string, error) {
-	directory := c.directory
+	return c.getSegmentDirectory(c.segments.Current(), namespace)
+}
+
+func (c *diskCollector) getSegmentDirectory(segment string, namespace string) (string, error) {
+	directory := filepath.Join(c.directory, segment)
 
 	if !c.flatFiles {
-		directory = filepath.Join(c.directory, namespace)
+		directory = filepath.Join(directory, namespace)
 	}
 
 	if err := os.MkdirAll(directory, 0755); err != nil {
@@ -60,21 +159,63 @@ func (c *diskCollector) getDirectory(namespace string) (string, error) {
 	return directory, nil
 }
 
+// podFileName returns the base name for all files that belong to a pod. The
+// first pod with a given name simply uses its name, but if a pod is
+// recreated with the same name (e.g. StatefulSet pods), the new pod gets
+// a generation suffix like "name~2", so that the files of the previous pod
+// are not overwritten.
+func (c *diskCollector) podFileName(namespace string, name string, uid types.UID) string {
+	if uid == "" {
+		return name
+	}
+
+	c.namesLock.Lock()
+	defer c.namesLock.Unlock()
+
+	generation, exists := c.podGenerations[uid]
+	if !exists {
+		key := fmt.Sprintf("%s/%s", namespace, name)
+
+		c.nameGenerations[key]++
+		generation = c.nameGenerations[key]
+		c.podGenerations[uid] = generation
+	}
+
+	if generation == 1 {
+		return name
+	}
+
+	return fmt.Sprintf("%s~%d", name, generation)
+}
+
 func (c *diskCollector) CollectPodMetadata(ctx context.Context, pod *corev1.Pod) error {
 	directory, err := c.getDirectory(pod.Namespace)
 	if err != nil {
 		return err
 	}
 
-	filename := filepath.Join(directory, fmt.Sprintf("%s.yaml", pod.Name))
+	pod.APIVersion = "v1"
+	pod.Kind = "Pod"
 
-	// file exists already, do not overwrite
-	if _, err := os.Stat(filename); err == nil {
-		return nil
+	if pod.DeletionTimestamp != nil {
+		c.forgetPodLater(pod.UID)
 	}
 
-	pod.APIVersion = "v1"
-	pod.Kind = "Pod"
+	c.expirePods()
+
+	if c.metadataHistory {
+		if err := c.appendPodHistory(directory, pod); err != nil {
+			return err
+		}
+	}
+
+	filename := filepath.Join(directory, fmt.Sprintf("%s.yaml", c.podFileName(pod.Namespace, pod.Name, pod.UID)))
+
+	// file exists already, do not overwrite, unless the pod is being deleted;
+	// this ensures that the final state of each pod is recorded
+	if _, err := os.Stat(filename); err == nil && pod.DeletionTimestamp == nil {
+		return nil
+	}
 
 	encoded, err := yaml.Marshal(pod)
 	if err != nil {
@@ -84,18 +225,181 @@ func (c *diskCollector) CollectPodMetadata(ctx context.Context, pod *corev1.Pod)
 	return os.WriteFile(filename, encoded, 0644)
 }
 
+// appendPodHistory appends the pod to its history file, unless it is the
+// same revision as the last one written or only differs in irrelevant fields.
+func (c *diskCollector) appendPodHistory(directory string, pod *corev1.Pod) error {
+	filename := filepath.Join(directory, fmt.Sprintf("%s.history.yaml", c.podFileName(pod.Namespace, pod.Name, pod.UID)))
+
+	trimmedPod := pod.DeepCopy()
+	trimmedPod.ManagedFields = nil
+
+	// the checksum is calculated without the resourceVersion, so that
+	// updates that only changed ignored fields are skipped as well
+	trimmedPod.ResourceVersion = ""
+
+	unversioned, err := yaml.Marshal(trimmedPod)
+	if err != nil {
+		return err
+	}
+
+	revision := podRevision{
+		uid:             pod.UID,
+		resourceVersion: pod.ResourceVersion,
+		checksum:        sha256.Sum256(unversioned),
+	}
+
+	c.historyLock.Lock()
+	defer c.historyLock.Unlock()
+
+	if last, exists := c.podRevisions[filename]; exists {
+		if last.resourceVersion == revision.resourceVersion || last.checksum == revision.checksum {
+			return nil
+		}
+	}
+
+	trimmedPod.ResourceVersion = pod.ResourceVersion
+
+	encoded, err := yaml.Marshal(trimmedPod)
+	if err != nil {
+		return err
+	}
+
+	if err := appendYAMLDocument(filename, encoded); err != nil {
+		return err
+	}
+
+	c.podRevisions[filename] = revision
+
+	return nil
+}
+
+// podRetention is how long a deleted pod is remembered. Its events can be
+// updated for as long as events are retained, so it must not be forgotten
+// earlier, or these events would end up in a file for a new pod.
+const podRetention = eventRetention
+
+// forgetPodLater remembers when the pod was deleted.
+func (c *diskCollector) forgetPodLater(uid types.UID) {
+	if uid == "" {
+		return
+	}
+
+	c.namesLock.Lock()
+	defer c.namesLock.Unlock()
+
+	if _, exists := c.deletedPods[uid]; !exists {
+		c.deletedPods[uid] = c.now()
+	}
+}
+
+// expirePods forgets all pods that were deleted longer than the retention
+// ago. Only the latest generation of each pod name is kept, so that file
+// names remain stable. To keep this cheap, it only does something once per
+// minute.
+func (c *diskCollector) expirePods() {
+	c.namesLock.Lock()
+
+	now := c.now()
+	if now.Sub(c.lastPodExpiry) < time.Minute {
+		c.namesLock.Unlock()
+		return
+	}
+
+	c.lastPodExpiry = now
+
+	expired := map[types.UID]struct{}{}
+	for uid, deleted := range c.deletedPods {
+		if now.Sub(deleted) > podRetention {
+			expired[uid] = struct{}{}
+			delete(c.deletedPods, uid)
+			delete(c.podGenerations, uid)
+		}
+	}
+
+	c.namesLock.Unlock()
+
+	if len(expired) == 0 {
+		return
+	}
+
+	c.historyLock.Lock()
+	defer c.historyLock.Unlock()
+
+	for filename, revision := range c.podRevisions {
+		if _, exists := expired[revision.uid]; exists {
+			delete(c.podRevisions, filename)
+		}
+	}
+}
+
+func (c *diskCollector) CollectTimeline(ctx context.Context, pod *corev1.Pod, entries []TimelineEntry) error {
+	directory, err := c.getDirectory(pod.Namespace)
+	if err != nil {
+		return err
+	}
+
+	basename := c.podFileName(pod.Namespace, pod.Name, pod.UID)
+
+	return writeTimeline(filepath.Join(directory, basename), entries)
+}
+
+// writeTimeline appends the entries to both the human readable and the JSON
+// lines timeline file, whose names are derived from the given base path.
+func writeTimeline(basePath string, entries []TimelineEntry) error {
+	var (
+		text       strings.Builder
+		structured bytes.Buffer
+	)
+
+	encoder := json.NewEncoder(&structured)
+
+	for _, entry := range entries {
+		text.WriteString(entry.String())
+		text.WriteString("\n")
+
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	if err := appendToFile(basePath+".timeline.log", []byte(text.String())); err != nil {
+		return err
+	}
+
+	return appendToFile(basePath+".timeline.jsonl", structured.Bytes())
+}
+
+func (c *diskCollector) CollectNodeTimeline(ctx context.Context, node *corev1.Node, entries []TimelineEntry) error {
+	directory, err := c.getNodeDirectory()
+	if err != nil {
+		return err
+	}
+
+	return writeTimeline(filepath.Join(directory, node.Name), entries)
+}
+
 func (c *diskCollector) CollectEvent(ctx context.Context, event *corev1.Event) error {
 	if !c.eventsAsText && !c.rawEvents {
 		return errors.New("event dumping is not enabled")
 	}
 
-	directory, err := c.getDirectory(event.InvolvedObject.Namespace)
+	if err := c.expireEvents(); err != nil {
+		return err
+	}
+
+	if c.isEventReplay(event) {
+		return nil
+	}
+
+	directory, err := c.getEventDirectory(event.InvolvedObject)
 	if err != nil {
 		return err
 	}
 
 	if c.eventsAsText {
-		if err := c.dumpEventAsText(directory, event); err != nil {
+		if c.collapseEvents {
+			c.collapseEvent(directory, event)
+		} else if err := c.dumpEventAsText(directory, event); err != nil {
 			return err
 		}
 	}
@@ -109,30 +413,299 @@ func (c *diskCollector) CollectEvent(ctx context.Context, event *corev1.Event) e
 	return nil
 }
 
-func (c *diskCollector) dumpEventAsText(directory string, event *corev1.Event) error {
-	filename := filepath.Join(directory, fmt.Sprintf("%s.events.log", event.InvolvedObject.Name))
+// isEventReplay returns true if the exact same version of the event has
+// already been collected (e.g. because it was part of the initial list and
+// then sent again by the watch). It also remembers the event's version.
+func (c *diskCollector) isEventReplay(event *corev1.Event) bool {
+	if event.UID == "" {
+		return false
+	}
+
+	c.eventsLock.Lock()
+	defer c.eventsLock.Unlock()
 
-	stringified := fmt.Sprintf("%s: [%s]", event.LastTimestamp.Format(time.RFC1123), event.Type)
-	if event.Source.Component != "" {
-		stringified = fmt.Sprintf("%s [%s]", stringified, event.Source.Component)
+	if version, exists := c.eventVersions[event.UID]; exists && version.resourceVersion == event.ResourceVersion {
+		return true
 	}
-	stringified = fmt.Sprintf("%s %s (reason: %s) (%dx)\n", stringified, event.Message, event.Reason, event.Count)
 
-	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	c.eventVersions[event.UID] = eventVersion{
+		resourceVersion: event.ResourceVersion,
+		seen:            c.now(),
+	}
+
+	return false
+}
+
+// eventRetention is how long events are remembered after their last
+// update. Kubernetes deletes events one hour after their last update by
+// default, so older events are neither updated nor replayed anymore.
+const eventRetention = time.Hour
+
+// expireEvents forgets all events that have not been updated for longer
+// than the retention, so that long runs do not accumulate all events in
+// memory. Expired collapsed events are written right away. To keep this
+// cheap, it only does something once per minute.
+func (c *diskCollector) expireEvents() error {
+	c.eventsLock.Lock()
+	defer c.eventsLock.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastExpiry) < time.Minute {
+		return nil
+	}
+
+	c.lastExpiry = now
+
+	for uid, version := range c.eventVersions {
+		if now.Sub(version.seen) > eventRetention {
+			delete(c.eventVersions, uid)
+		}
+	}
+
+	var errs []error
+
+	remaining := c.collapsedEvents[:0]
+	for _, collapsed := range c.collapsedEvents {
+		if now.Sub(collapsed.seen) <= eventRetention {
+			remaining = append(remaining, collapsed)
+			continue
+		}
+
+		if err := c.dumpEventAsText(collapsed.directory, collapsed.event); err != nil {
+			errs = append(errs, err)
+		}
+
+		delete(c.collapsedIndex, collapsed.event.UID)
+	}
+
+	clear(c.collapsedEvents[len(remaining):])
+	c.collapsedEvents = remaining
+
+	return errors.Join(errs...)
+}
+
+// collapseEvent remembers the latest version of an event. Events are kept
+// in the order they were first seen.
+func (c *diskCollector) collapseEvent(directory string, event *corev1.Event) {
+	c.eventsLock.Lock()
+	defer c.eventsLock.Unlock()
+
+	if existing, exists := c.collapsedIndex[event.UID]; exists && event.UID != "" {
+		existing.event = event
+		existing.seen = c.now()
+		return
+	}
+
+	collapsed := &collapsedEvent{
+		directory: directory,
+		event:     event,
+		seen:      c.now(),
+	}
+
+	c.collapsedEvents = append(c.collapsedEvents, collapsed)
+	if event.UID != "" {
+		c.collapsedIndex[event.UID] = collapsed
+	}
+}
+
+// Close writes all collapsed events, even if some of them fail.
+func (c *diskCollector) Close() error {
+	c.eventsLock.Lock()
+	defer c.eventsLock.Unlock()
+
+	var errs []error
+
+	for _, collapsed := range c.collapsedEvents {
+		if err := c.dumpEventAsText(collapsed.directory, collapsed.event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	c.collapsedEvents = nil
+	c.collapsedIndex = map[types.UID]*collapsedEvent{}
+
+	return errors.Join(errs...)
+}
+
+// clusterScopeDirectory is used instead of a namespace for events of
+// cluster-scoped objects like PersistentVolumes.
+const clusterScopeDirectory = "_cluster"
+
+// nodeDirectory contains the timelines and events of all nodes. It is used
+// regardless of the flat files option, so that nodes and pods with the same
+// name do not overwrite each other.
+const nodeDirectory = "nodes"
+
+func (c *diskCollector) getNodeDirectory() (string, error) {
+	directory := filepath.Join(c.directory, c.segments.Current(), nodeDirectory)
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %q: %w", directory, err)
+	}
+
+	return directory, nil
+}
+
+// incidentDirectory contains one directory per incident. Like the node
+// directory, it is used regardless of the flat files option.
+const incidentDirectory = "incidents"
+
+// CollectIncident writes all information about the incident into a new
+// directory named "<time>_<pod>_<container>_<restarts>". The restart count
+// keeps incidents of a crash looping container apart, even if they happen
+// within the same second.
+func (c *diskCollector) CollectIncident(ctx context.Context, incident *Incident) error {
+	podName := c.podFileName(incident.Pod.Namespace, incident.Pod.Name, incident.Pod.UID)
+	name := fmt.Sprintf("%s_%s_%s_%03d", incident.Time.Format("2006.01.02T15.04.05"), podName, incident.Container, incident.RestartCount)
+	directory := filepath.Join(c.directory, c.segments.Current(), incidentDirectory, name)
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %q: %w", directory, err)
+	}
+
+	files := map[string][]byte{
+		"incident.txt": []byte(incident.summary()),
+		fmt.Sprintf("%s.log", incident.Container): []byte(incident.Logs),
+	}
+
+	pod := incident.Pod.DeepCopy()
+	pod.APIVersion = "v1"
+	pod.Kind = "Pod"
+	pod.ManagedFields = nil
+
+	encoded, err := yaml.Marshal(pod)
 	if err != nil {
 		return err
 	}
 
-	_, err = f.WriteString(stringified)
-	if err1 := f.Close(); err1 != nil && err == nil {
-		err = err1
+	files["pod.yaml"] = encoded
+
+	var events strings.Builder
+	for i := range incident.Events {
+		events.WriteString(formatEvent(&incident.Events[i]))
 	}
 
-	return err
+	files["events.log"] = []byte(events.String())
+
+	if incident.Node != nil {
+		files["node.log"] = []byte(incident.nodeConditions())
+	}
+
+	for filename, content := range files {
+		if err := os.WriteFile(filepath.Join(directory, filename), content, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isPodReference(obj corev1.ObjectReference) bool {
+	return obj.Kind == "Pod" && obj.APIVersion == "v1"
+}
+
+// getEventDirectory returns the directory for events of the given object.
+// Pod events are stored next to the pod logs, whereas events of all other
+// kinds are stored in a "<namespace>/<kind>" directory. Node events are
+// stored next to the node timelines.
+func (c *diskCollector) getEventDirectory(obj corev1.ObjectReference) (string, error) {
+	if isPodReference(obj) {
+		return c.getDirectory(obj.Namespace)
+	}
+
+	if obj.Kind == "Node" {
+		return c.getNodeDirectory()
+	}
+
+	namespace := obj.Namespace
+	if namespace == "" {
+		namespace = clusterScopeDirectory
+	}
+
+	directory, err := c.getDirectory(namespace)
+	if err != nil {
+		return "", err
+	}
+
+	directory = filepath.Join(directory, obj.Kind)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory %q: %w", directory, err)
+	}
+
+	return directory, nil
+}
+
+func (c *diskCollector) eventFileName(event *corev1.Event) string {
+	obj := event.InvolvedObject
+
+	if !isPodReference(obj) {
+		return obj.Name
+	}
+
+	return c.podFileName(obj.Namespace, obj.Name, obj.UID)
+}
+
+func (c *diskCollector) dumpEventAsText(directory string, event *corev1.Event) error {
+	filename := filepath.Join(directory, fmt.Sprintf("%s.events.log", c.eventFileName(event)))
+
+	return appendToFile(filename, []byte(formatEvent(event)))
+}
+
+// formatEvent returns the event as a human readable line.
+func formatEvent(event *corev1.Event) string {
+	stringified := fmt.Sprintf("%s: [%s]", eventTime(event).Format(time.RFC1123), event.Type)
+	if component := eventComponent(event); component != "" {
+		stringified = fmt.Sprintf("%s [%s]", stringified, component)
+	}
+	stringified = fmt.Sprintf("%s %s (reason: %s)", stringified, event.Message, event.Reason)
+	if event.Action != "" {
+		stringified = fmt.Sprintf("%s (action: %s)", stringified, event.Action)
+	}
+
+	return fmt.Sprintf("%s (%dx)\n", stringified, eventCount(event))
+}
+
+// eventTime returns the most recent time at which the event was observed.
+// Newer components only set EventTime and Series, whereas older components
+// only set the timestamps.
+func eventTime(event *corev1.Event) time.Time {
+	candidates := []time.Time{event.LastTimestamp.Time, event.EventTime.Time, event.FirstTimestamp.Time, event.CreationTimestamp.Time}
+	if event.Series != nil {
+		candidates = append([]time.Time{event.Series.LastObservedTime.Time}, candidates...)
+	}
+
+	for _, t := range candidates {
+		if !t.IsZero() {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func eventCount(event *corev1.Event) int32 {
+	if event.Series != nil && event.Series.Count > 0 {
+		return event.Series.Count
+	}
+
+	if event.Count > 0 {
+		return event.Count
+	}
+
+	// events without series or count have only happened once
+	return 1
+}
+
+func eventComponent(event *corev1.Event) string {
+	if event.ReportingController != "" {
+		return event.ReportingController
+	}
+
+	return event.Source.Component
 }
 
 func (c *diskCollector) dumpEventAsYAML(directory string, event *corev1.Event) error {
-	filename := filepath.Join(directory, fmt.Sprintf("%s.events.yaml", event.InvolvedObject.Name))
+	filename := filepath.Join(directory, fmt.Sprintf("%s.events.yaml", c.eventFileName(event)))
 
 	trimmedEvent := event.DeepCopy()
 	trimmedEvent.ManagedFields = nil
@@ -142,15 +715,23 @@ func (c *diskCollector) dumpEventAsYAML(directory string, event *corev1.Event) e
 		return err
 	}
 
+	return appendYAMLDocument(filename, encoded)
+}
+
+func appendYAMLDocument(filename string, encoded []byte) error {
 	encoded = append([]byte("---\n"), encoded...)
 	encoded = append(encoded, []byte("\n")...)
 
+	return appendToFile(filename, encoded)
+}
+
+func appendToFile(filename string, data []byte) error {
 	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
 		return err
 	}
 
-	_, err = f.Write(encoded)
+	_, err = f.Write(data)
 	if err1 := f.Close(); err1 != nil && err == nil {
 		err = err1
 	}
@@ -159,14 +740,59 @@ func (c *diskCollector) dumpEventAsYAML(directory string, event *corev1.Event) e
 }
 
 func (c *diskCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error {
-	directory, err := c.getDirectory(pod.Namespace)
+	basename := fmt.Sprintf("%s_%s_%03d", c.podFileName(pod.Namespace, pod.Name, pod.UID), containerName, getContainerIncarnation(pod, containerName))
+
+	if !c.structuredLogs {
+		return c.writeLogs(log, pod.Namespace, basename+".log", stream)
+	}
+
+	// write the parsed lines into a second file while the raw logs are
+	// being written
+	pipeReader, pipeWriter := io.Pipe()
+	structuredErr := make(chan error, 1)
+
+	go func() {
+		parser := &lineParser{}
+		err := c.writeLogs(log, pod.Namespace, basename+".jsonl", NewLineReader(pipeReader, parser.structuredJSON))
+
+		// do not block the raw logs if the structured logs failed
+		_, _ = io.Copy(io.Discard, pipeReader)
+		structuredErr <- err
+	}()
+
+	err := c.writeLogs(log, pod.Namespace, basename+".log", io.TeeReader(stream, pipeWriter))
+	pipeWriter.Close()
+
+	return errors.Join(err, <-structuredErr)
+}
+
+func (c *diskCollector) writeLogs(log logrus.FieldLogger, namespace string, filename string, stream io.Reader) error {
+	if c.segments == nil {
+		directory, err := c.getDirectory(namespace)
+		if err != nil {
+			return err
+		}
+
+		return c.copyLogs(filepath.Join(directory, filename), stream)
+	}
+
+	return c.copySegmentedLogs(log, namespace, filename, stream)
+}
+
+func (c *diskCollector) CollectNodeLogs(ctx context.Context, log logrus.FieldLogger, nodeName string, logName string, stream io.Reader) error {
+	directory, err := c.getNodeDirectory()
 	if err != nil {
 		return err
 	}
 
-	filename := fmt.Sprintf("%s_%s_%03d.log", pod.Name, containerName, getContainerIncarnation(pod, containerName))
-	filename = filepath.Join(directory, filename)
+	// logName can also be a path like "audit/audit.log"
+	logName = unsafeNameChars.ReplaceAllString(strings.TrimSuffix(logName, ".log"), "_")
+	filename := fmt.Sprintf("%s.%s.log", nodeName, logName)
+
+	return c.copyLogs(filepath.Join(directory, filename), stream)
+}
 
+func (c *diskCollector) copyLogs(filename string, stream io.Reader) error {
 	f, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("failed to open log file %q: %w", filename, err)
@@ -181,6 +807,78 @@ func (c *diskCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger,
 	return nil
 }
 
+// copySegmentedLogs works like copyLogs, but checks the current segment
+// before writing each line and switches to a new file whenever the segment
+// has changed.
+func (c *diskCollector) copySegmentedLogs(log logrus.FieldLogger, namespace string, filename string, stream io.Reader) error {
+	var (
+		f       *os.File
+		segment string
+		path    string
+	)
+
+	openFile := func() error {
+		if f != nil {
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("failed to close log file %q: %w", path, err)
+			}
+		}
+
+		segment = c.segments.Current()
+
+		directory, err := c.getSegmentDirectory(segment, namespace)
+		if err != nil {
+			return err
+		}
+
+		path = filepath.Join(directory, filename)
+
+		// use append mode in case the same segment is continued after a
+		// container restart with the same restart count
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file %q: %w", path, err)
+		}
+
+		return nil
+	}
+
+	if err := openFile(); err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+	}()
+
+	rd := bufio.NewReader(stream)
+
+	for {
+		line, readErr := rd.ReadBytes('\n')
+
+		if len(line) > 0 {
+			if current := c.segments.Current(); current != segment {
+				log.WithField("segment", current).Debug("Switching to new segment.")
+
+				if err := openFile(); err != nil {
+					return err
+				}
+			}
+
+			if _, err := f.Write(line); err != nil {
+				return fmt.Errorf("failed to write to log file %q: %w", path, err)
+			}
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			return nil
+		}
+
+		if readErr != nil {
+			return fmt.Errorf("failed to read logs: %w", readErr)
+		}
+	}
+}
+
 func getContainerIncarnation(pod *corev1.Pod, containerName string) int {
 	for _, s := range pod.Status.ContainerStatuses {
 		if s.Name == containerName {
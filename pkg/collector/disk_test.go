@@ -0,0 +1,674 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newTestPod(resourceVersion string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            "test",
+			ResourceVersion: resourceVersion,
+			ManagedFields: []metav1.ManagedFieldsEntry{{
+				Manager: resourceVersion,
+			}},
+		},
+		Status: corev1.PodStatus{
+			Phase: phase,
+		},
+	}
+}
+
+func TestPodMetadataHistory(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+
+	coll, err := NewDiskCollector(directory, DiskOptions{MetadataHistory: true})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	deleted := newTestPod("5", corev1.PodSucceeded)
+	deleted.DeletionTimestamp = &metav1.Time{}
+
+	pods := []*corev1.Pod{
+		newTestPod("1", corev1.PodPending),
+		// exact replay
+		newTestPod("1", corev1.PodPending),
+		newTestPod("2", corev1.PodRunning),
+		// only managedFields and resourceVersion changed
+		newTestPod("3", corev1.PodRunning),
+		newTestPod("4", corev1.PodSucceeded),
+		deleted,
+	}
+
+	for _, pod := range pods {
+		if err := coll.CollectPodMetadata(ctx, pod); err != nil {
+			t.Fatalf("Failed to collect metadata: %v", err)
+		}
+	}
+
+	history, err := os.ReadFile(filepath.Join(directory, "default", "test.history.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read history: %v", err)
+	}
+
+	var versions []string
+	for _, line := range strings.Split(string(history), "\n") {
+		if strings.HasPrefix(line, "  resourceVersion:") {
+			versions = append(versions, strings.Trim(strings.TrimPrefix(line, "  resourceVersion: "), `"`))
+		}
+	}
+
+	if expected := "1,2,4,5"; strings.Join(versions, ",") != expected {
+		t.Fatalf("Expected history to contain revisions %s, but got %v.", expected, versions)
+	}
+
+	if strings.Contains(string(history), "managedFields") {
+		t.Fatal("History should not contain managedFields.")
+	}
+
+	// the regular metadata file must contain the final state
+	final, err := os.ReadFile(filepath.Join(directory, "default", "test.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+
+	if !strings.Contains(string(final), `resourceVersion: "5"`) {
+		t.Fatalf("Expected metadata to contain the final state, but got:\n%s", string(final))
+	}
+}
+
+func TestReusedPodNames(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+
+	coll, err := NewDiskCollector(directory, DiskOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	for _, uid := range []types.UID{"uid-1", "uid-2", "uid-1", "uid-3"} {
+		pod := newTestPod("1", corev1.PodRunning)
+		pod.UID = uid
+
+		if err := coll.CollectLogs(ctx, logrus.New(), pod, "app", strings.NewReader(string(uid))); err != nil {
+			t.Fatalf("Failed to collect logs: %v", err)
+		}
+	}
+
+	expected := map[string]string{
+		"test_app_000.log":   "uid-1",
+		"test~2_app_000.log": "uid-2",
+		"test~3_app_000.log": "uid-3",
+	}
+
+	for filename, content := range expected {
+		data, err := os.ReadFile(filepath.Join(directory, "default", filename))
+		if err != nil {
+			t.Fatalf("Failed to read log file: %v", err)
+		}
+
+		if string(data) != content {
+			t.Errorf("Expected %s to contain %q, but got %q.", filename, content, string(data))
+		}
+	}
+}
+
+func TestDeletedPodExpiry(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+
+	coll, err := NewDiskCollector(directory, DiskOptions{MetadataHistory: true})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	now := time.Now()
+	disk := coll.(*diskCollector)
+	disk.now = func() time.Time { return now }
+
+	deleted := newTestPod("1", corev1.PodSucceeded)
+	deleted.UID = "uid-1"
+
+	deletionTime := metav1.NewTime(now)
+	deleted.DeletionTimestamp = &deletionTime
+
+	if err := coll.CollectPodMetadata(ctx, deleted); err != nil {
+		t.Fatalf("Failed to collect metadata: %v", err)
+	}
+
+	now = now.Add(2 * podRetention)
+
+	pod := newTestPod("2", corev1.PodRunning)
+	pod.UID = "uid-2"
+
+	if err := coll.CollectPodMetadata(ctx, pod); err != nil {
+		t.Fatalf("Failed to collect metadata: %v", err)
+	}
+
+	if _, exists := disk.podGenerations["uid-1"]; exists {
+		t.Error("Expected deleted pod to be forgotten.")
+	}
+
+	if len(disk.deletedPods) != 0 || len(disk.podRevisions) != 1 {
+		t.Errorf("Expected only the new pod to be remembered, got %d deleted pods and %d revisions.", len(disk.deletedPods), len(disk.podRevisions))
+	}
+
+	// the new pod must not overwrite the files of the deleted one
+	if _, err := os.Stat(filepath.Join(directory, "default", "test~2.yaml")); err != nil {
+		t.Errorf("Expected the new pod to use a new file name: %v", err)
+	}
+}
+
+func TestEventDirectories(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+
+	coll, err := NewDiskCollector(directory, DiskOptions{EventsAsText: true})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	events := []corev1.ObjectReference{
+		{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "my-pod"},
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "my-deployment"},
+		{APIVersion: "v1", Kind: "Node", Name: "my-node"},
+	}
+
+	for _, obj := range events {
+		event := &corev1.Event{InvolvedObject: obj, Message: "hello"}
+
+		if err := coll.CollectEvent(ctx, event); err != nil {
+			t.Fatalf("Failed to collect event: %v", err)
+		}
+	}
+
+	for _, filename := range []string{
+		"default/my-pod.events.log",
+		"default/Deployment/my-deployment.events.log",
+		"nodes/my-node.events.log",
+	} {
+		if _, err := os.Stat(filepath.Join(directory, filename)); err != nil {
+			t.Errorf("Expected %s to exist: %v", filename, err)
+		}
+	}
+}
+
+func TestEventText(t *testing.T) {
+	ts := metav1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	pod := corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "test"}
+
+	testcases := []struct {
+		name     string
+		event    corev1.Event
+		expected string
+	}{
+		{
+			name: "legacy event",
+			event: corev1.Event{
+				InvolvedObject: pod,
+				Type:           corev1.EventTypeNormal,
+				Source:         corev1.EventSource{Component: "kubelet"},
+				Message:        "Pulled image",
+				Reason:         "Pulled",
+				LastTimestamp:  ts,
+				Count:          3,
+			},
+			expected: "Tue, 02 Jan 2024 03:04:05 UTC: [Normal] [kubelet] Pulled image (reason: Pulled) (3x)\n",
+		},
+		{
+			name: "new-style event",
+			event: corev1.Event{
+				InvolvedObject:      pod,
+				Type:                corev1.EventTypeWarning,
+				ReportingController: "default-scheduler",
+				Action:              "Scheduling",
+				Message:             "0/3 nodes are available",
+				Reason:              "FailedScheduling",
+				EventTime:           metav1.NewMicroTime(ts.Time.Add(-time.Hour)),
+				Series: &corev1.EventSeries{
+					Count:            7,
+					LastObservedTime: metav1.NewMicroTime(ts.Time),
+				},
+			},
+			expected: "Tue, 02 Jan 2024 03:04:05 UTC: [Warning] [default-scheduler] 0/3 nodes are available (reason: FailedScheduling) (action: Scheduling) (7x)\n",
+		},
+		{
+			name: "single new-style event",
+			event: corev1.Event{
+				InvolvedObject:      pod,
+				Type:                corev1.EventTypeNormal,
+				ReportingController: "kubelet",
+				Message:             "Started container",
+				Reason:              "Started",
+				EventTime:           metav1.NewMicroTime(ts.Time),
+			},
+			expected: "Tue, 02 Jan 2024 03:04:05 UTC: [Normal] [kubelet] Started container (reason: Started) (1x)\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			directory := t.TempDir()
+
+			coll, err := NewDiskCollector(directory, DiskOptions{EventsAsText: true})
+			if err != nil {
+				t.Fatalf("Failed to create collector: %v", err)
+			}
+
+			if err := coll.CollectEvent(context.Background(), &tc.event); err != nil {
+				t.Fatalf("Failed to collect event: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(directory, "default", "test.events.log"))
+			if err != nil {
+				t.Fatalf("Failed to read events: %v", err)
+			}
+
+			if string(data) != tc.expected {
+				t.Fatalf("Expected\n%q\nbut got\n%q", tc.expected, string(data))
+			}
+		})
+	}
+}
+
+func TestEventDeduplication(t *testing.T) {
+	pod := corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "test"}
+
+	newEvent := func(uid types.UID, resourceVersion string, count int32) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				UID:             uid,
+				ResourceVersion: resourceVersion,
+			},
+			InvolvedObject: pod,
+			Message:        string(uid),
+			Count:          count,
+		}
+	}
+
+	events := []*corev1.Event{
+		newEvent("a", "1", 1),
+		newEvent("b", "2", 1),
+		// replay of the same version
+		newEvent("a", "1", 1),
+		// series update
+		newEvent("a", "3", 2),
+		newEvent("a", "4", 3),
+	}
+
+	testcases := []struct {
+		collapse bool
+		expected []string
+	}{
+		{
+			collapse: false,
+			expected: []string{"a (reason: ) (1x)", "b (reason: ) (1x)", "a (reason: ) (2x)", "a (reason: ) (3x)"},
+		},
+		{
+			collapse: true,
+			expected: []string{"a (reason: ) (3x)", "b (reason: ) (1x)"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(fmt.Sprintf("collapse=%v", tc.collapse), func(t *testing.T) {
+			directory := t.TempDir()
+
+			coll, err := NewDiskCollector(directory, DiskOptions{EventsAsText: true, CollapseEvents: tc.collapse})
+			if err != nil {
+				t.Fatalf("Failed to create collector: %v", err)
+			}
+
+			for _, event := range events {
+				if err := coll.CollectEvent(context.Background(), event); err != nil {
+					t.Fatalf("Failed to collect event: %v", err)
+				}
+			}
+
+			if err := coll.Close(); err != nil {
+				t.Fatalf("Failed to close collector: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(directory, "default", "test.events.log"))
+			if err != nil {
+				t.Fatalf("Failed to read events: %v", err)
+			}
+
+			var lines []string
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				// strip timestamp, type and component
+				lines = append(lines, strings.TrimSpace(strings.SplitN(line, "] ", 2)[1]))
+			}
+
+			if strings.Join(lines, "\n") != strings.Join(tc.expected, "\n") {
+				t.Fatalf("Expected\n%s\n\nbut got\n%s", strings.Join(tc.expected, "\n"), strings.Join(lines, "\n"))
+			}
+		})
+	}
+}
+
+func TestEventExpiry(t *testing.T) {
+	directory := t.TempDir()
+
+	coll, err := NewDiskCollector(directory, DiskOptions{EventsAsText: true, CollapseEvents: true})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	now := time.Now()
+	disk := coll.(*diskCollector)
+	disk.now = func() time.Time { return now }
+
+	newEvent := func(uid types.UID) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				UID:             uid,
+				ResourceVersion: "1",
+			},
+			InvolvedObject: corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "test"},
+			Message:        string(uid),
+		}
+	}
+
+	if err := coll.CollectEvent(context.Background(), newEvent("a")); err != nil {
+		t.Fatalf("Failed to collect event: %v", err)
+	}
+
+	now = now.Add(2 * eventRetention)
+
+	if err := coll.CollectEvent(context.Background(), newEvent("b")); err != nil {
+		t.Fatalf("Failed to collect event: %v", err)
+	}
+
+	if _, exists := disk.eventVersions["a"]; exists {
+		t.Error("Expected expired event to be forgotten.")
+	}
+
+	if len(disk.collapsedEvents) != 1 || len(disk.collapsedIndex) != 1 {
+		t.Errorf("Expected only one collapsed event to remain, got %d.", len(disk.collapsedEvents))
+	}
+
+	// the expired event must have been written before closing the collector
+	data, err := os.ReadFile(filepath.Join(directory, "default", "test.events.log"))
+	if err != nil {
+		t.Fatalf("Failed to read events: %v", err)
+	}
+
+	if !strings.Contains(string(data), "] a (reason: )") || strings.Contains(string(data), "] b (reason: )") {
+		t.Fatalf("Expected only the expired event to be written, got:\n%s", string(data))
+	}
+
+	if err := coll.Close(); err != nil {
+		t.Fatalf("Failed to close collector: %v", err)
+	}
+}
+
+// steppedReader returns one chunk per Read and calls before right before
+// returning a chunk, so that tests can act between two chunks.
+type steppedReader struct {
+	chunks []string
+	read   int
+	before func(chunk int)
+}
+
+func (r *steppedReader) Read(p []byte) (int, error) {
+	if r.read == len(r.chunks) {
+		return 0, io.EOF
+	}
+
+	if r.before != nil {
+		r.before(r.read)
+	}
+
+	n := copy(p, r.chunks[r.read])
+	r.read++
+
+	return n, nil
+}
+
+func TestSegmentedLogs(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+	segments := NewSegments()
+
+	if _, err := segments.Start("first"); err != nil {
+		t.Fatalf("Failed to start segment: %v", err)
+	}
+
+	coll, err := NewDiskCollector(directory, DiskOptions{EventsAsText: true, Segments: segments})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	// the first line has been written completely when the second chunk
+	// is requested, so the new segment starts in the middle of the stream
+	stream := &steppedReader{
+		chunks: []string{"before\n", "after\n"},
+		before: func(chunk int) {
+			if chunk == 1 {
+				if _, err := segments.Start("second"); err != nil {
+					t.Errorf("Failed to start segment: %v", err)
+				}
+			}
+		},
+	}
+
+	pod := newTestPod("1", corev1.PodRunning)
+
+	if err := coll.CollectLogs(ctx, logrus.New(), pod, "app", stream); err != nil {
+		t.Fatalf("Failed to collect logs: %v", err)
+	}
+
+	event := &corev1.Event{
+		InvolvedObject: corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "test"},
+		Message:        "hello",
+	}
+
+	if err := coll.CollectEvent(ctx, event); err != nil {
+		t.Fatalf("Failed to collect event: %v", err)
+	}
+
+	if err := coll.CollectPodMetadata(ctx, pod); err != nil {
+		t.Fatalf("Failed to collect metadata: %v", err)
+	}
+
+	for _, check := range []struct {
+		filename string
+		expected string
+		exact    bool
+	}{
+		{filename: "001-first/default/test_app_000.log", expected: "before\n", exact: true},
+		{filename: "002-second/default/test_app_000.log", expected: "after\n", exact: true},
+		{filename: "002-second/default/test.events.log", expected: "hello"},
+		{filename: "002-second/default/test.yaml", expected: "name: test"},
+	} {
+		content, err := os.ReadFile(filepath.Join(directory, check.filename))
+		if err != nil {
+			t.Errorf("Failed to read %s: %v", check.filename, err)
+			continue
+		}
+
+		if check.exact && string(content) != check.expected {
+			t.Errorf("Expected %s to be %q, got %q.", check.filename, check.expected, string(content))
+		} else if !strings.Contains(string(content), check.expected) {
+			t.Errorf("Expected %s to contain %q, got %q.", check.filename, check.expected, string(content))
+		}
+	}
+
+	for _, filename := range []string{"001-first/default/test.events.log", "001-first/default/test.yaml"} {
+		if _, err := os.Stat(filepath.Join(directory, filename)); err == nil {
+			t.Errorf("Expected %s not to exist, as it was collected after the segment has changed.", filename)
+		}
+	}
+}
+
+func TestCloseWritesAllCollapsedEvents(t *testing.T) {
+	directory := t.TempDir()
+
+	coll, err := NewDiskCollector(directory, DiskOptions{EventsAsText: true, CollapseEvents: true})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	for _, name := range []string{"broken", "test"} {
+		event := &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{UID: types.UID(name)},
+			InvolvedObject: corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: name},
+			Message:        name,
+		}
+
+		if err := coll.CollectEvent(context.Background(), event); err != nil {
+			t.Fatalf("Failed to collect event: %v", err)
+		}
+	}
+
+	// make writing the first event fail
+	coll.(*diskCollector).collapsedEvents[0].directory = filepath.Join(directory, "missing")
+
+	if err := coll.Close(); err == nil {
+		t.Error("Expected an error for the first event.")
+	}
+
+	if _, err := os.Stat(filepath.Join(directory, "default", "test.events.log")); err != nil {
+		t.Errorf("Expected the second event to be written anyway: %v", err)
+	}
+}
+
+func TestNodeLogFiles(t *testing.T) {
+	ctx := context.Background()
+	directory := t.TempDir()
+
+	coll, err := NewDiskCollector(directory, DiskOptions{FlatFiles: true})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	for _, logName := range []string{"kubelet", "audit/audit.log"} {
+		if err := coll.CollectNodeLogs(ctx, logrus.New(), "my-node", logName, strings.NewReader("hello\n")); err != nil {
+			t.Fatalf("Failed to collect node logs: %v", err)
+		}
+	}
+
+	for _, filename := range []string{
+		"nodes/my-node.kubelet.log",
+		"nodes/my-node.audit_audit.log",
+	} {
+		if _, err := os.Stat(filepath.Join(directory, filename)); err != nil {
+			t.Errorf("Expected %s to exist: %v", filename, err)
+		}
+	}
+}
+
+func TestStructuredLogs(t *testing.T) {
+	directory := t.TempDir()
+
+	coll, err := NewDiskCollector(directory, DiskOptions{FlatFiles: true, StructuredLogs: true})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "my-pod"}}
+	input := "starting\nlevel=info msg=ready\n"
+
+	if err := coll.CollectLogs(context.Background(), logrus.New(), pod, "app", strings.NewReader(input)); err != nil {
+		t.Fatalf("Failed to collect logs: %v", err)
+	}
+
+	for filename, expected := range map[string]string{
+		"my-pod_app_000.log":   input,
+		"my-pod_app_000.jsonl": `{"raw":"starting"}` + "\n" + `{"format":"logfmt","level":"info","message":"ready","fields":{"level":"info","msg":"ready"}}` + "\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(directory, filename))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filename, err)
+		}
+
+		if string(content) != expected {
+			t.Errorf("Expected %s to contain %q, got %q.", filename, expected, string(content))
+		}
+	}
+}
+
+func TestIncidentBundle(t *testing.T) {
+	directory := t.TempDir()
+
+	coll, err := NewDiskCollector(directory, DiskOptions{FlatFiles: true})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	exitCode := int32(137)
+	incident := &Incident{
+		Time:         time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Pod:          newTestPod("1", corev1.PodRunning),
+		Container:    "app",
+		RestartCount: 2,
+		ExitCode:     &exitCode,
+		Reason:       "OOMKilled",
+		Logs:         "allocating\n",
+		Events: []corev1.Event{{
+			Type:    corev1.EventTypeWarning,
+			Reason:  "BackOff",
+			Message: "Back-off restarting failed container",
+		}},
+		Node: &corev1.Node{
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeMemoryPressure, Status: corev1.ConditionTrue}},
+			},
+		},
+	}
+
+	if err := coll.CollectIncident(context.Background(), incident); err != nil {
+		t.Fatalf("Failed to collect incident: %v", err)
+	}
+
+	// a second incident within the same second must not overwrite the first
+	next := *incident
+	next.RestartCount = 3
+
+	if err := coll.CollectIncident(context.Background(), &next); err != nil {
+		t.Fatalf("Failed to collect incident: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(directory, "incidents", "2023.01.02T03.04.05_test_app_003", "incident.txt")); err != nil {
+		t.Errorf("Expected second incident to be stored separately: %v", err)
+	}
+
+	bundle := filepath.Join(directory, "incidents", "2023.01.02T03.04.05_test_app_002")
+
+	for filename, expected := range map[string]string{
+		"incident.txt": "Exit code:     137",
+		"app.log":      "allocating\n",
+		"pod.yaml":     "kind: Pod",
+		"events.log":   "Back-off restarting failed container (reason: BackOff)",
+		"node.log":     "MemoryPressure=True",
+	} {
+		content, err := os.ReadFile(filepath.Join(bundle, filename))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filename, err)
+		}
+
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Expected %s to contain %q, but got:\n%s", filename, expected, string(content))
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type FilterOptions struct {
+	// Include are regular expressions of which at least one must match a
+	// line. If empty, all lines are included.
+	Include []string
+	// Exclude are regular expressions that drop every line they match. An
+	// excluded line is not even shown as context.
+	Exclude []string
+	// Before is the number of lines before an included line to keep.
+	Before int
+	// After is the number of lines after an included line to keep.
+	After int
+}
+
+type filterCollector struct {
+	inner   Collector
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	before  int
+	after   int
+}
+
+var _ Collector = &filterCollector{}
+
+// NewFilterCollector wraps a Collector and only passes the log lines
+// matching the given filters on to it. All other data is passed through
+// unchanged.
+func NewFilterCollector(inner Collector, opt FilterOptions) (Collector, error) {
+	include, err := compilePatterns(opt.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := compilePatterns(opt.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Before < 0 || opt.After < 0 {
+		return nil, fmt.Errorf("context lines must not be negative")
+	}
+
+	return &filterCollector{
+		inner:   inner,
+		include: include,
+		exclude: exclude,
+		before:  opt.Before,
+		after:   opt.After,
+	}, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+
+		result = append(result, re)
+	}
+
+	return result, nil
+}
+
+func (c *filterCollector) CollectEvent(ctx context.Context, event *corev1.Event) error {
+	return c.inner.CollectEvent(ctx, event)
+}
+
+func (c *filterCollector) CollectTimeline(ctx context.Context, pod *corev1.Pod, entries []TimelineEntry) error {
+	return c.inner.CollectTimeline(ctx, pod, entries)
+}
+
+func (c *filterCollector) CollectNodeTimeline(ctx context.Context, node *corev1.Node, entries []TimelineEntry) error {
+	return c.inner.CollectNodeTimeline(ctx, node, entries)
+}
+
+func (c *filterCollector) CollectPodMetadata(ctx context.Context, pod *corev1.Pod) error {
+	return c.inner.CollectPodMetadata(ctx, pod)
+}
+
+// CollectIncident does not filter the logs of the incident, as they are
+// meant to show everything that led to it.
+func (c *filterCollector) CollectIncident(ctx context.Context, incident *Incident) error {
+	return c.inner.CollectIncident(ctx, incident)
+}
+
+func (c *filterCollector) Close() error {
+	return c.inner.Close()
+}
+
+func (c *filterCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error {
+	return c.inner.CollectLogs(ctx, log, pod, containerName, c.newFilterReader(stream))
+}
+
+func (c *filterCollector) CollectNodeLogs(ctx context.Context, log logrus.FieldLogger, nodeName string, logName string, stream io.Reader) error {
+	return c.inner.CollectNodeLogs(ctx, log, nodeName, logName, c.newFilterReader(stream))
+}
+
+func (c *filterCollector) newFilterReader(stream io.Reader) io.Reader {
+	filter := &lineFilter{collector: c}
+
+	return NewLineReader(stream, filter.process)
+}
+
+func (c *filterCollector) excluded(line string) bool {
+	for _, re := range c.exclude {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *filterCollector) included(line string) bool {
+	if len(c.include) == 0 {
+		return true
+	}
+
+	for _, re := range c.include {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lineFilter decides for each line of a stream whether it passes the
+// filters, including the context lines around included lines.
+type lineFilter struct {
+	collector *filterCollector
+
+	// previous contains up to collector.before lines that were not
+	// included, but might become context of the next included line.
+	previous []string
+	// remaining is the number of lines that still follow as context of the
+	// last included line.
+	remaining int
+}
+
+func (f *lineFilter) process(line string) string {
+	content := strings.TrimRight(line, "\r\n")
+
+	if f.collector.excluded(content) {
+		return ""
+	}
+
+	switch {
+	case f.collector.included(content):
+		output := strings.Join(f.previous, "") + line
+
+		f.previous = f.previous[:0]
+		f.remaining = f.collector.after
+
+		return output
+
+	case f.remaining > 0:
+		f.remaining--
+
+		return line
+
+	case f.collector.before > 0:
+		if len(f.previous) == f.collector.before {
+			f.previous = f.previous[1:]
+		}
+
+		f.previous = append(f.previous, line)
+	}
+
+	return ""
+}
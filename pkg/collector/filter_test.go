@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestFilterCollector(t *testing.T) {
+	input := strings.Join([]string{
+		"starting",
+		"GET /healthz",
+		"loading config",
+		"level=error msg=broken",
+		"retrying",
+		"GET /healthz",
+		"still retrying",
+		"giving up",
+		"panic: oh no",
+		"goroutine 1",
+		"level=info msg=done",
+	}, "\n")
+
+	testcases := []struct {
+		name     string
+		opt      FilterOptions
+		expected []string
+	}{
+		{
+			name:     "no filters",
+			opt:      FilterOptions{},
+			expected: strings.Split(input, "\n"),
+		},
+		{
+			name: "include",
+			opt:  FilterOptions{Include: []string{"level=error", "^panic"}},
+			expected: []string{
+				"level=error msg=broken",
+				"panic: oh no",
+			},
+		},
+		{
+			name: "exclude",
+			opt:  FilterOptions{Exclude: []string{"healthz"}},
+			expected: []string{
+				"starting",
+				"loading config",
+				"level=error msg=broken",
+				"retrying",
+				"still retrying",
+				"giving up",
+				"panic: oh no",
+				"goroutine 1",
+				"level=info msg=done",
+			},
+		},
+		{
+			name: "context",
+			opt:  FilterOptions{Include: []string{"level=error", "^panic"}, Exclude: []string{"healthz"}, Before: 1, After: 1},
+			expected: []string{
+				"loading config",
+				"level=error msg=broken",
+				"retrying",
+				"giving up",
+				"panic: oh no",
+				"goroutine 1",
+			},
+		},
+		{
+			name: "last line without newline",
+			opt:  FilterOptions{Include: []string{"done"}},
+			expected: []string{
+				"level=info msg=done",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			inner := &recordingCollector{}
+
+			coll, err := NewFilterCollector(inner, tc.opt)
+			if err != nil {
+				t.Fatalf("Failed to create collector: %v", err)
+			}
+
+			// read byte by byte to ensure lines are not required to arrive in one piece
+			stream := iotest.OneByteReader(strings.NewReader(input))
+
+			if err := coll.CollectLogs(context.Background(), logrus.New(), &corev1.Pod{}, "app", stream); err != nil {
+				t.Fatalf("Failed to collect logs: %v", err)
+			}
+
+			expected := strings.Join(tc.expected, "\n")
+			if !strings.HasSuffix(input, tc.expected[len(tc.expected)-1]) {
+				expected += "\n"
+			}
+
+			if inner.logs != expected {
+				t.Fatalf("Unexpected logs.\nExpected:\n%s\n\nActual:\n%s", expected, inner.logs)
+			}
+		})
+	}
+}
+
+func TestInvalidFilter(t *testing.T) {
+	if _, err := NewFilterCollector(&recordingCollector{}, FilterOptions{Include: []string{"("}}); err == nil {
+		t.Fatal("Expected an error for an invalid pattern.")
+	}
+}
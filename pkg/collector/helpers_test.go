@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"context"
+	"io"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// recordingCollector records the logs, events and pod metadata it
+// receives. Logs can be read using a custom function instead, and events
+// and pod metadata are answered with its error.
+type recordingCollector struct {
+	streamCollector
+
+	read  func(stream io.Reader) error
+	err   error
+	logs  string
+	event *corev1.Event
+	pod   *corev1.Pod
+}
+
+func (c *recordingCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error {
+	if c.read != nil {
+		return c.read(stream)
+	}
+
+	content, err := io.ReadAll(stream)
+	c.logs = string(content)
+
+	return err
+}
+
+func (c *recordingCollector) CollectPodMetadata(ctx context.Context, pod *corev1.Pod) error {
+	c.pod = pod
+	return c.err
+}
+
+func (c *recordingCollector) CollectEvent(ctx context.Context, event *corev1.Event) error {
+	c.event = event
+	return c.err
+}
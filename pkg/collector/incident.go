@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Incident is a restart or failure of a container, together with the state
+// of its pod and node at that moment.
+type Incident struct {
+	Time      time.Time
+	Pod       *corev1.Pod
+	Container string
+	// RestartCount identifies the incarnation of the container that failed.
+	RestartCount int32
+	// ExitCode and Reason describe the termination of the incarnation, if
+	// it is known.
+	ExitCode *int32
+	Reason   string
+	// Logs are the last lines of the failed incarnation.
+	Logs string
+	// Events are the events of the pod, sorted by time.
+	Events []corev1.Event
+	// Node is nil if the node of the pod is unknown.
+	Node *corev1.Node
+}
+
+// summary returns a human readable description of the incident.
+func (i *Incident) summary() string {
+	var summary strings.Builder
+
+	fmt.Fprintf(&summary, "Time:          %s\n", i.Time.Format(time.RFC1123))
+	fmt.Fprintf(&summary, "Pod:           %s/%s\n", i.Pod.Namespace, i.Pod.Name)
+	fmt.Fprintf(&summary, "Container:     %s\n", i.Container)
+	fmt.Fprintf(&summary, "Restart count: %d\n", i.RestartCount)
+
+	if i.ExitCode != nil {
+		fmt.Fprintf(&summary, "Exit code:     %d\n", *i.ExitCode)
+	}
+
+	if i.Reason != "" {
+		fmt.Fprintf(&summary, "Reason:        %s\n", i.Reason)
+	}
+
+	if i.Pod.Spec.NodeName != "" {
+		fmt.Fprintf(&summary, "Node:          %s\n", i.Pod.Spec.NodeName)
+	}
+
+	return summary.String()
+}
+
+// nodeConditions returns a human readable list of the conditions and
+// taints of the node.
+func (i *Incident) nodeConditions() string {
+	var conditions strings.Builder
+
+	for _, condition := range i.Node.Status.Conditions {
+		fmt.Fprintf(&conditions, "%s: %s=%s (reason: %s) %s\n", condition.LastTransitionTime.Format(time.RFC1123), condition.Type, condition.Status, condition.Reason, condition.Message)
+	}
+
+	for _, taint := range i.Node.Spec.Taints {
+		if taint.Value == "" {
+			fmt.Fprintf(&conditions, "Taint: %s:%s\n", taint.Key, taint.Effect)
+		} else {
+			fmt.Fprintf(&conditions, "Taint: %s=%s:%s\n", taint.Key, taint.Value, taint.Effect)
+		}
+	}
+
+	if i.Node.Spec.Unschedulable {
+		conditions.WriteString("Cordoned\n")
+	}
+
+	return conditions.String()
+}
@@ -15,5 +15,15 @@ import (
 type Collector interface {
 	CollectPodMetadata(ctx context.Context, pod *corev1.Pod) error
 	CollectEvent(ctx context.Context, event *corev1.Event) error
+	CollectTimeline(ctx context.Context, pod *corev1.Pod, entries []TimelineEntry) error
+	CollectNodeTimeline(ctx context.Context, node *corev1.Node, entries []TimelineEntry) error
 	CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error
+	// CollectNodeLogs receives the logs of a service or log file (logName)
+	// of a node. Implementations must consume the entire stream.
+	CollectNodeLogs(ctx context.Context, log logrus.FieldLogger, nodeName string, logName string, stream io.Reader) error
+	// CollectIncident receives a bundle of information about a container
+	// that restarted or failed.
+	CollectIncident(ctx context.Context, incident *Incident) error
+	// Close is called once after all data has been collected.
+	Close() error
 }
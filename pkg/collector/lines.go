@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"bufio"
+	"io"
+)
+
+// lineReader reads a stream line by line and returns the output of process
+// for each line instead. Lines are processed lazily while the reader is
+// consumed, so it works for followed streams as well.
+type lineReader struct {
+	source  *bufio.Reader
+	process func(line string) string
+	pending []byte
+	err     error
+}
+
+// NewLineReader returns a reader that passes each line of the stream,
+// including its line break, through process. process can return an empty
+// string to drop a line or multiple lines at once.
+func NewLineReader(stream io.Reader, process func(line string) string) io.Reader {
+	return &lineReader{
+		source:  bufio.NewReader(stream),
+		process: process,
+	}
+}
+
+func (r *lineReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		line, err := r.source.ReadString('\n')
+		if err != nil {
+			r.err = err
+		}
+
+		// the last line might not end with a newline, but is still a line
+		if line != "" {
+			r.pending = append(r.pending, r.process(line)...)
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+
+	return n, nil
+}
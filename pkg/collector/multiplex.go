@@ -4,8 +4,12 @@
 package collector
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"slices"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -14,54 +18,303 @@ import (
 )
 
 type multiplexCollector struct {
-	a Collector
-	b Collector
+	collectors []Collector
 }
 
 var _ Collector = &multiplexCollector{}
 
-func NewMultiplexCollector(a, b Collector) (Collector, error) {
+// NewMultiplexCollector returns a Collector that passes all data to each of
+// the given collectors. Every collector is always called, even if others
+// fail; all errors are returned together. Log streams are buffered for each
+// collector individually and the stream is read as fast as the fastest
+// collector reads, so that a slow collector never stalls the others. Once a
+// collector falls behind by more than the buffer size, its oldest buffered
+// data is dropped, so that slow collectors cannot exhaust the memory; the
+// number of dropped bytes is returned as an error once the stream has ended.
+func NewMultiplexCollector(collectors ...Collector) (Collector, error) {
+	if len(collectors) == 0 {
+		return nil, errors.New("no collectors given")
+	}
+
 	return &multiplexCollector{
-		a: a,
-		b: b,
+		collectors: collectors,
 	}, nil
 }
 
-func (c *multiplexCollector) CollectEvent(ctx context.Context, event *corev1.Event) error {
-	if err := c.a.CollectEvent(ctx, event); err != nil {
-		return err
+// each calls fn for every collector and returns all errors.
+func (c *multiplexCollector) each(fn func(coll Collector) error) error {
+	var errs []error
+
+	for i, coll := range c.collectors {
+		if err := fn(coll); err != nil {
+			errs = append(errs, fmt.Errorf("collector %d: %w", i+1, err))
+		}
 	}
 
-	return c.b.CollectEvent(ctx, event)
+	return errors.Join(errs...)
+}
+
+func (c *multiplexCollector) CollectEvent(ctx context.Context, event *corev1.Event) error {
+	return c.each(func(coll Collector) error {
+		return coll.CollectEvent(ctx, event)
+	})
+}
+
+func (c *multiplexCollector) CollectTimeline(ctx context.Context, pod *corev1.Pod, entries []TimelineEntry) error {
+	return c.each(func(coll Collector) error {
+		return coll.CollectTimeline(ctx, pod, entries)
+	})
+}
+
+func (c *multiplexCollector) CollectNodeTimeline(ctx context.Context, node *corev1.Node, entries []TimelineEntry) error {
+	return c.each(func(coll Collector) error {
+		return coll.CollectNodeTimeline(ctx, node, entries)
+	})
 }
 
 func (c *multiplexCollector) CollectPodMetadata(ctx context.Context, pod *corev1.Pod) error {
-	if err := c.a.CollectPodMetadata(ctx, pod); err != nil {
-		return err
-	}
+	return c.each(func(coll Collector) error {
+		return coll.CollectPodMetadata(ctx, pod)
+	})
+}
+
+func (c *multiplexCollector) CollectIncident(ctx context.Context, incident *Incident) error {
+	return c.each(func(coll Collector) error {
+		return coll.CollectIncident(ctx, incident)
+	})
+}
 
-	return c.b.CollectPodMetadata(ctx, pod)
+func (c *multiplexCollector) Close() error {
+	return c.each(func(coll Collector) error {
+		return coll.Close()
+	})
 }
 
 func (c *multiplexCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error {
-	pipeReader, pipeWriter := io.Pipe()
-	teeReader := io.TeeReader(stream, pipeWriter)
-
-	waiter := sync.WaitGroup{}
-	waiter.Add(1)
-	go func() {
-		_ = c.a.CollectLogs(ctx, log, pod, containerName, teeReader)
-		pipeWriter.Close()
-		waiter.Done()
-	}()
-
-	waiter.Add(1)
-	go func() {
-		_ = c.b.CollectLogs(ctx, log, pod, containerName, pipeReader)
-		waiter.Done()
-	}()
-
-	waiter.Wait()
-
-	return nil
+	return c.fanOut(stream, func(coll Collector, r io.Reader) error {
+		return coll.CollectLogs(ctx, log, pod, containerName, r)
+	})
+}
+
+func (c *multiplexCollector) CollectNodeLogs(ctx context.Context, log logrus.FieldLogger, nodeName string, logName string, stream io.Reader) error {
+	return c.fanOut(stream, func(coll Collector, r io.Reader) error {
+		return coll.CollectNodeLogs(ctx, log, nodeName, logName, r)
+	})
+}
+
+// fanOut feeds the stream into all collectors concurrently and waits for all
+// of them to finish. The stream is read until it ends or until all
+// collectors have stopped reading. A read error of the stream is passed on
+// to all collectors and returned only once, together with all other errors
+// and the data that had to be dropped for slow collectors.
+func (c *multiplexCollector) fanOut(stream io.Reader, collect func(coll Collector, r io.Reader) error) error {
+	buffers := make([]*streamBuffer, len(c.collectors))
+	errs := make([]error, len(c.collectors))
+	room := make(chan struct{}, 1)
+	wg := sync.WaitGroup{}
+
+	for i, coll := range c.collectors {
+		buffers[i] = newStreamBuffer(room)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			errs[i] = collect(coll, buffers[i])
+
+			// a collector that returned early must not hold back the others
+			buffers[i].stop()
+		}()
+	}
+
+	readErr := pump(stream, buffers, room)
+
+	for _, buffer := range buffers {
+		buffer.close(readErr)
+	}
+
+	wg.Wait()
+
+	result := []error{readErr}
+	for i, err := range errs {
+		if err != nil && (readErr == nil || !errors.Is(err, readErr)) {
+			result = append(result, fmt.Errorf("collector %d: %w", i+1, err))
+		}
+
+		if dropped := buffers[i].dropped; dropped > 0 {
+			result = append(result, fmt.Errorf("collector %d: dropped %d bytes of the stream because it could not keep up", i+1, dropped))
+		}
+	}
+
+	return errors.Join(result...)
+}
+
+// pump copies the stream into all buffers and returns the read error, if
+// the stream did not end with io.EOF. Before each read, it waits until at
+// least one buffer has room, which is signalled via room.
+func pump(stream io.Reader, buffers []*streamBuffer, room <-chan struct{}) error {
+	chunk := make([]byte, streamChunkSize)
+
+	for {
+		for !slices.ContainsFunc(buffers, (*streamBuffer).hasRoom) {
+			<-room
+		}
+
+		n, err := stream.Read(chunk)
+		if n > 0 {
+			// the data is shared by all buffers, which only ever read it
+			data := bytes.Clone(chunk[:n])
+
+			active := 0
+			for _, buffer := range buffers {
+				if buffer.push(data) {
+					active++
+				}
+			}
+
+			if active == 0 {
+				return nil
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+const (
+	// streamChunkSize is the maximum number of bytes that are read from the
+	// stream at once.
+	streamChunkSize = 32 * 1024
+
+	// streamBufferSize is the number of bytes that are buffered for each
+	// collector before its oldest data is dropped.
+	streamBufferSize = 256 * 1024
+)
+
+// streamBuffer is an io.Reader that holds the data for a single collector
+// until the collector reads it. Writing into it never blocks; when it is
+// full, the oldest data is dropped instead.
+type streamBuffer struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	chunks [][]byte
+	size   int
+	// room is signalled whenever the buffer gained room.
+	room chan<- struct{}
+	// dropped is the number of bytes that were dropped because the buffer
+	// was full. It must only be read once the collector has finished.
+	dropped int
+	// closed is true once the stream has ended, err is the reason why.
+	closed bool
+	err    error
+	// stopped is true once the collector does not read anymore.
+	stopped bool
+}
+
+func newStreamBuffer(room chan<- struct{}) *streamBuffer {
+	b := &streamBuffer{room: room}
+	b.cond = sync.NewCond(&b.lock)
+
+	return b
+}
+
+// hasRoom returns true if the buffer has room for another chunk or the
+// collector has stopped reading, so that pushing into it does not drop any
+// data.
+func (b *streamBuffer) hasRoom() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.size+streamChunkSize <= streamBufferSize || b.stopped
+}
+
+// signalRoom wakes up the pump if it is waiting for room.
+func (b *streamBuffer) signalRoom() {
+	select {
+	case b.room <- struct{}{}:
+	default:
+	}
+}
+
+// push appends data to the buffer, dropping the oldest chunks if the buffer
+// has no room for it, and returns false if the collector has stopped reading.
+func (b *streamBuffer) push(data []byte) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.stopped {
+		return false
+	}
+
+	for len(b.chunks) > 0 && b.size+len(data) > streamBufferSize {
+		b.dropped += len(b.chunks[0])
+		b.size -= len(b.chunks[0])
+		b.chunks[0] = nil
+		b.chunks = b.chunks[1:]
+	}
+
+	b.chunks = append(b.chunks, data)
+	b.size += len(data)
+	b.cond.Broadcast()
+
+	return true
+}
+
+// close marks the end of the stream; once all buffered data has been read,
+// Read returns err (or io.EOF if err is nil).
+func (b *streamBuffer) close(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	b.err = err
+	b.cond.Broadcast()
+}
+
+// stop discards all buffered data and makes all further pushes fail.
+func (b *streamBuffer) stop() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.stopped = true
+	b.chunks = nil
+	b.size = 0
+	b.cond.Broadcast()
+	b.signalRoom()
+}
+
+func (b *streamBuffer) Read(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for len(b.chunks) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+
+	if len(b.chunks) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+
+		return 0, io.EOF
+	}
+
+	n := copy(p, b.chunks[0])
+	if n == len(b.chunks[0]) {
+		b.chunks[0] = nil
+		b.chunks = b.chunks[1:]
+	} else {
+		b.chunks[0] = b.chunks[0][n:]
+	}
+
+	b.size -= n
+	b.signalRoom()
+
+	return n, nil
 }
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMultiplexLogs(t *testing.T) {
+	// the input fits into the buffer of the slow collector
+	input := strings.Repeat("a log line\n", 10000)
+	errBroken := errors.New("broken")
+
+	// the healthy collectors report when they have read everything
+	healthyFinished := make(chan string)
+	healthyDone := make(chan struct{})
+
+	var healthy []*recordingCollector
+	for range 3 {
+		healthy = append(healthy, &recordingCollector{read: func(stream io.Reader) error {
+			content, err := io.ReadAll(stream)
+			healthyFinished <- string(content)
+
+			return err
+		}})
+	}
+
+	// a collector that fails after the first read
+	failing := &recordingCollector{read: func(stream io.Reader) error {
+		_, _ = stream.Read(make([]byte, 10))
+		return errBroken
+	}}
+
+	// a collector that only starts reading once all others are done, which
+	// must not stall them as long as its buffer is not full
+	slow := &recordingCollector{}
+	slow.read = func(stream io.Reader) error {
+		<-healthyDone
+
+		content, err := io.ReadAll(stream)
+		slow.logs = string(content)
+
+		return err
+	}
+
+	coll, err := NewMultiplexCollector(healthy[0], failing, healthy[1], slow, healthy[2])
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	result := make(chan error)
+	go func() {
+		result <- coll.CollectLogs(context.Background(), logrus.New(), &corev1.Pod{}, "app", strings.NewReader(input))
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for range healthy {
+		select {
+		case logs := <-healthyFinished:
+			if logs != input {
+				t.Errorf("Healthy collector received %d bytes, expected %d.", len(logs), len(input))
+			}
+
+		case <-deadline:
+			t.Fatal("Healthy collectors were stalled by the slow collector.")
+		}
+	}
+
+	close(healthyDone)
+
+	err = <-result
+	if !errors.Is(err, errBroken) {
+		t.Fatalf("Expected error of failing collector to be returned, got %v.", err)
+	}
+
+	if slow.logs != input {
+		t.Errorf("Slow collector received %d bytes, expected %d.", len(slow.logs), len(input))
+	}
+}
+
+func TestMultiplexSlowCollector(t *testing.T) {
+	input := strings.Repeat("x", 10*streamBufferSize)
+
+	// a collector that never reads and only returns once the test is done
+	unblock := make(chan struct{})
+	blocked := &recordingCollector{read: func(stream io.Reader) error {
+		<-unblock
+		return nil
+	}}
+
+	fastFinished := make(chan string)
+	fast := &recordingCollector{read: func(stream io.Reader) error {
+		content, err := io.ReadAll(stream)
+		fastFinished <- string(content)
+
+		return err
+	}}
+
+	coll, err := NewMultiplexCollector(fast, blocked)
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	result := make(chan error)
+	go func() {
+		result <- coll.CollectLogs(context.Background(), logrus.New(), &corev1.Pod{}, "app", strings.NewReader(input))
+	}()
+
+	select {
+	case logs := <-fastFinished:
+		if logs != input {
+			t.Errorf("Fast collector received %d bytes, expected %d.", len(logs), len(input))
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatal("Fast collector was stalled by the blocked collector.")
+	}
+
+	close(unblock)
+
+	// everything that did not fit into the buffer was dropped
+	expected := fmt.Sprintf("collector 2: dropped %d bytes", len(input)-streamBufferSize)
+	if err := <-result; err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("Expected dropped bytes of the blocked collector to be reported, got %v.", err)
+	}
+}
+
+func TestMultiplexReadError(t *testing.T) {
+	errStream := errors.New("stream broken")
+	sinks := []*recordingCollector{{}, {}}
+
+	coll, err := NewMultiplexCollector(sinks[0], sinks[1])
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	stream := io.MultiReader(strings.NewReader("partial"), &failingReader{err: errStream})
+
+	err = coll.CollectLogs(context.Background(), logrus.New(), &corev1.Pod{}, "app", stream)
+	if !errors.Is(err, errStream) {
+		t.Fatalf("Expected stream error, got %v.", err)
+	}
+
+	// the error is passed to all collectors, but reported only once
+	if strings.Count(err.Error(), errStream.Error()) != 1 {
+		t.Errorf("Expected stream error to be reported once, got %q.", err.Error())
+	}
+
+	for i, sink := range sinks {
+		if sink.logs != "partial" {
+			t.Errorf("Collector %d received %q.", i+1, sink.logs)
+		}
+	}
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+func TestMultiplexErrors(t *testing.T) {
+	errA := errors.New("a failed")
+	errC := errors.New("c failed")
+
+	coll, err := NewMultiplexCollector(&recordingCollector{err: errA}, &recordingCollector{}, &recordingCollector{err: errC})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	err = coll.CollectEvent(context.Background(), &corev1.Event{})
+	if !errors.Is(err, errA) || !errors.Is(err, errC) {
+		t.Fatalf("Expected errors of all collectors, got %v.", err)
+	}
+
+	if _, err := NewMultiplexCollector(); err == nil {
+		t.Fatal("Expected an error without collectors.")
+	}
+}
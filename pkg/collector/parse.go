@@ -0,0 +1,252 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON   = "json"
+	formatLogfmt = "logfmt"
+)
+
+// StructuredLine is a parsed log line. Lines that could not be parsed only
+// have their Raw content set.
+type StructuredLine struct {
+	// Format is either "json" or "logfmt".
+	Format string `json:"format,omitempty"`
+	// Level and Message are taken from the well-known fields (e.g. "level"
+	// or "msg"), if present.
+	Level   string         `json:"level,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Fields  map[string]any `json:"fields,omitempty"`
+	Raw     string         `json:"raw,omitempty"`
+}
+
+var (
+	levelKeys   = []string{"level", "lvl", "severity"}
+	messageKeys = []string{"msg", "message"}
+	timeKeys    = []string{"time", "ts", "timestamp"}
+)
+
+// lineParser parses the lines of a single log stream. Containers usually
+// stick to a single log format, so the format of the last parsed line is
+// tried first.
+type lineParser struct {
+	format string
+}
+
+// parse returns the structured representation of a line (without its line
+// break). If the line is neither JSON nor logfmt, nil is returned.
+func (p *lineParser) parse(line string) *StructuredLine {
+	formats := []string{formatJSON, formatLogfmt}
+	if p.format == formatLogfmt {
+		formats = []string{formatLogfmt, formatJSON}
+	}
+
+	for _, format := range formats {
+		var fields map[string]any
+
+		switch format {
+		case formatJSON:
+			fields = parseJSON(line)
+		case formatLogfmt:
+			fields = parseLogfmt(line)
+		}
+
+		if fields != nil {
+			p.format = format
+
+			return &StructuredLine{
+				Format:  format,
+				Level:   stringField(fields, levelKeys),
+				Message: stringField(fields, messageKeys),
+				Fields:  fields,
+			}
+		}
+	}
+
+	return nil
+}
+
+func parseJSON(line string) map[string]any {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil || decoder.More() {
+		return nil
+	}
+
+	return fields
+}
+
+// parseLogfmt parses lines like `level=info msg="hello world" count=3`. To
+// not mistake regular text for logfmt, the line must consist entirely of
+// key/value pairs and contain at least two of them.
+func parseLogfmt(line string) map[string]any {
+	fields := map[string]any{}
+	rest := strings.TrimSpace(line)
+
+	for rest != "" {
+		separator := strings.IndexAny(rest, "= \"")
+		if separator <= 0 || rest[separator] != '=' {
+			return nil
+		}
+
+		key := rest[:separator]
+		rest = rest[separator+1:]
+
+		var value string
+
+		if strings.HasPrefix(rest, `"`) {
+			end := closingQuote(rest)
+			if end < 0 {
+				return nil
+			}
+
+			unquoted, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return nil
+			}
+
+			value = unquoted
+			rest = rest[end+1:]
+
+			if rest != "" && rest[0] != ' ' {
+				return nil
+			}
+		} else {
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+
+			value = rest[:end]
+			if strings.ContainsAny(value, `="`) {
+				return nil
+			}
+
+			rest = rest[end:]
+		}
+
+		fields[key] = value
+		rest = strings.TrimLeft(rest, " ")
+	}
+
+	if len(fields) < 2 {
+		return nil
+	}
+
+	return fields
+}
+
+// structuredJSON returns the line as a JSON object, followed by a line break.
+func (p *lineParser) structuredJSON(line string) string {
+	content := strings.TrimRight(line, "\r\n")
+
+	parsed := p.parse(content)
+	if parsed == nil {
+		parsed = &StructuredLine{Raw: content}
+	}
+
+	encoded, err := json.Marshal(parsed)
+	if err != nil {
+		encoded, _ = json.Marshal(StructuredLine{Raw: content})
+	}
+
+	return string(encoded) + "\n"
+}
+
+// closingQuote returns the index of the quote that ends the quoted string
+// at the beginning of s, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+
+	return -1
+}
+
+func stringField(fields map[string]any, keys []string) string {
+	for _, key := range keys {
+		if value, exists := fields[key]; exists {
+			return fmt.Sprint(value)
+		}
+	}
+
+	return ""
+}
+
+// pretty renders the line as "LEVEL message key=value ...". If keys are
+// given, only these fields are included, otherwise all fields except the
+// level, message and timestamp are.
+func (l *StructuredLine) pretty(keys []string) string {
+	var parts []string
+
+	if l.Level != "" {
+		// pad the level, so that messages line up
+		parts = append(parts, fmt.Sprintf("%-5s", strings.ToUpper(l.Level)))
+	}
+
+	if l.Message != "" {
+		parts = append(parts, l.Message)
+	}
+
+	if len(keys) == 0 {
+		for key := range l.Fields {
+			if !slices.Contains(levelKeys, key) && !slices.Contains(messageKeys, key) && !slices.Contains(timeKeys, key) {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+	}
+
+	for _, key := range keys {
+		if value, exists := l.Fields[key]; exists {
+			parts = append(parts, fmt.Sprintf("%s=%s", key, formatValue(value)))
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(parts, " "))
+}
+
+func formatValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" || strings.ContainsAny(v, " =\"") {
+			return fmt.Sprintf("%q", v)
+		}
+
+		return v
+
+	case map[string]any, []any:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+
+		return string(encoded)
+
+	default:
+		return fmt.Sprint(v)
+	}
+}
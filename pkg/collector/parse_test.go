@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"testing"
+)
+
+func TestParseLines(t *testing.T) {
+	testcases := []struct {
+		line     string
+		format   string
+		expected string
+	}{
+		{
+			line:     "just some text",
+			expected: "just some text",
+		},
+		{
+			line:     "retrying in 5s (attempt=3)",
+			expected: "retrying in 5s (attempt=3)",
+		},
+		{
+			line:     "key=value",
+			expected: "key=value",
+		},
+		{
+			line:     "{not json",
+			expected: "{not json",
+		},
+		{
+			line:     `{"level":"info","msg":"request done","time":"2024-01-02T03:04:05Z","status":200,"path":"/api"}`,
+			format:   formatJSON,
+			expected: "INFO  request done path=/api status=200",
+		},
+		{
+			line:     `{"severity":"error","message":"failed","error":{"code":1}}`,
+			format:   formatJSON,
+			expected: `ERROR failed error={"code":1}`,
+		},
+		{
+			line:     `ts=2024-01-02T03:04:05Z level=warn msg="disk almost full" usage=93% path="/var/lib/my data"`,
+			format:   formatLogfmt,
+			expected: `WARN  disk almost full path="/var/lib/my data" usage=93%`,
+		},
+		{
+			line:     `a=1 b="unterminated`,
+			expected: `a=1 b="unterminated`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.line, func(t *testing.T) {
+			parser := &lineParser{}
+
+			parsed := parser.parse(tc.line)
+			if tc.format == "" {
+				if parsed != nil {
+					t.Fatalf("Expected line not to be parsed, but got %+v.", parsed)
+				}
+
+				return
+			}
+
+			if parsed == nil {
+				t.Fatal("Expected line to be parsed.")
+			}
+
+			if parsed.Format != tc.format {
+				t.Errorf("Expected format %q, got %q.", tc.format, parsed.Format)
+			}
+
+			if pretty := parsed.pretty(nil); pretty != tc.expected {
+				t.Errorf("Expected %q, got %q.", tc.expected, pretty)
+			}
+		})
+	}
+}
+
+func TestPrettyFields(t *testing.T) {
+	parser := &lineParser{}
+
+	parsed := parser.parse(`level=info msg=hello user=admin request_id=123 duration=5ms`)
+	if parsed == nil {
+		t.Fatal("Expected line to be parsed.")
+	}
+
+	// unknown fields are skipped
+	expected := "INFO  hello request_id=123"
+	if pretty := parsed.pretty([]string{"request_id", "unknown"}); pretty != expected {
+		t.Fatalf("Expected %q, got %q.", expected, pretty)
+	}
+}
+
+func TestStructuredJSON(t *testing.T) {
+	parser := &lineParser{}
+
+	for line, expected := range map[string]string{
+		"level=info msg=hello\n":        `{"format":"logfmt","level":"info","message":"hello","fields":{"level":"info","msg":"hello"}}` + "\n",
+		`{"msg":"hi","count":3}` + "\n": `{"format":"json","message":"hi","fields":{"count":3,"msg":"hi"}}` + "\n",
+		"plain text\r\n":                `{"raw":"plain text"}` + "\n",
+	} {
+		if structured := parser.structuredJSON(line); structured != expected {
+			t.Errorf("Expected %q, got %q.", expected, structured)
+		}
+	}
+}
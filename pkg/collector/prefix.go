@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PrefixData is the data available to prefix templates.
+type PrefixData struct {
+	Namespace   string
+	Pod         string
+	Container   string
+	Node        string
+	PodIP       string
+	Restarts    int
+	Labels      map[string]string
+	Annotations map[string]string
+	// Owner is the workload owning the pod as "Kind/name", e.g.
+	// "Deployment/my-app", or an empty string for standalone pods.
+	Owner string
+	// Cluster is the name of the cluster in the current kubeconfig context.
+	Cluster string
+	// Time is the time the line was received.
+	Time time.Time
+}
+
+// prefixFormatter renders line prefixes, either using the legacy %-based
+// placeholders or a Go template. Templates are used as soon as the format
+// contains "{{".
+type prefixFormatter struct {
+	format   string
+	template *template.Template
+	cluster  string
+}
+
+var prefixFuncs = template.FuncMap{
+	"pad":     pad,
+	"padLeft": padLeft,
+	"trunc":   trunc,
+}
+
+func newPrefixFormatter(format string, cluster string) (*prefixFormatter, error) {
+	f := &prefixFormatter{
+		format:  format,
+		cluster: cluster,
+	}
+
+	if strings.Contains(format, "{{") {
+		tpl, err := template.New("prefix").Funcs(prefixFuncs).Option("missingkey=zero").Parse(format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix template: %w", err)
+		}
+
+		f.template = tpl
+	}
+
+	return f, nil
+}
+
+// static returns true if the prefix does not change from line to line.
+func (f *prefixFormatter) static() bool {
+	return f.template == nil
+}
+
+var placeholders = regexp.MustCompile(`%([a-zA-Z]+)`)
+
+func (f *prefixFormatter) prefix(pod *corev1.Pod, containerName string, now time.Time) (string, error) {
+	if f.template == nil {
+		return strings.TrimSpace(placeholders.ReplaceAllStringFunc(f.format, func(s string) string {
+			switch s {
+			case "%pn":
+				return pod.Name
+			case "%pN":
+				return pod.Namespace
+			case "%c":
+				return containerName
+			}
+
+			return s
+		})), nil
+	}
+
+	data := PrefixData{
+		Namespace:   pod.Namespace,
+		Pod:         pod.Name,
+		Container:   containerName,
+		Node:        pod.Spec.NodeName,
+		PodIP:       pod.Status.PodIP,
+		Restarts:    getContainerIncarnation(pod, containerName),
+		Labels:      pod.Labels,
+		Annotations: pod.Annotations,
+		Owner:       podOwner(pod),
+		Cluster:     f.cluster,
+		Time:        now,
+	}
+
+	var buf strings.Builder
+	if err := f.template.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render prefix: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// podOwner returns the controller of the pod. Pods owned by a ReplicaSet
+// that was created by a Deployment are attributed to the Deployment, which
+// is recognized by the pod-template-hash suffix of the ReplicaSet.
+func podOwner(pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ""
+	}
+
+	if owner.Kind == "ReplicaSet" {
+		if hash := pod.Labels["pod-template-hash"]; hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment/" + strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+
+	return owner.Kind + "/" + owner.Name
+}
+
+// pad appends spaces to s until it is at least width characters long.
+func pad(width int, s string) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+
+	return s
+}
+
+// padLeft prepends spaces to s until it is at least width characters long.
+func padLeft(width int, s string) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return strings.Repeat(" ", width-n) + s
+	}
+
+	return s
+}
+
+// trunc shortens s to at most width characters.
+func trunc(width int, s string) string {
+	if width < 0 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+
+	return string([]rune(s)[:width])
+}
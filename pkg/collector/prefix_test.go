@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPrefixFormats(t *testing.T) {
+	isController := true
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "my-app-7d9f8b6c5d-x2x4z",
+			Labels: map[string]string{
+				"app.kubernetes.io/name": "my-app",
+				"pod-template-hash":      "7d9f8b6c5d",
+			},
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind:       "ReplicaSet",
+				Name:       "my-app-7d9f8b6c5d",
+				Controller: &isController,
+			}},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+		},
+		Status: corev1.PodStatus{
+			PodIP: "10.0.0.1",
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", RestartCount: 3},
+			},
+		},
+	}
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testcases := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format:   "[%pN/%pn:%c] >>",
+			expected: "[default/my-app-7d9f8b6c5d-x2x4z:app] >>",
+		},
+		{
+			format:   "{{ .Cluster }}/{{ .Node }}/{{ .PodIP }} {{ .Owner }} #{{ .Restarts }}",
+			expected: "test/node-1/10.0.0.1 Deployment/my-app #3",
+		},
+		{
+			format:   `{{ index .Labels "app.kubernetes.io/name" }} {{ index .Annotations "missing" }}|`,
+			expected: "my-app |",
+		},
+		{
+			format:   `{{ .Time.Format "15:04:05" }} {{ .Pod | trunc 6 | pad 8 }}|{{ .Container | padLeft 5 }}|`,
+			expected: "03:04:05 my-app  |  app|",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.format, func(t *testing.T) {
+			f, err := newPrefixFormatter(tc.format, "test")
+			if err != nil {
+				t.Fatalf("Failed to parse format: %v", err)
+			}
+
+			prefix, err := f.prefix(pod, "app", now)
+			if err != nil {
+				t.Fatalf("Failed to render prefix: %v", err)
+			}
+
+			if prefix != tc.expected {
+				t.Fatalf("Expected %q, got %q.", tc.expected, prefix)
+			}
+		})
+	}
+}
+
+func TestInvalidPrefixTemplate(t *testing.T) {
+	if _, err := newPrefixFormatter("{{ .Pod ", ""); err == nil {
+		t.Fatal("Expected an error for an invalid template.")
+	}
+}
+
+func TestPodOwner(t *testing.T) {
+	isController := true
+
+	owned := func(kind string, name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels:          labels,
+				OwnerReferences: []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &isController}},
+			},
+		}
+	}
+
+	testcases := []struct {
+		pod      *corev1.Pod
+		expected string
+	}{
+		{pod: &corev1.Pod{}, expected: ""},
+		{pod: owned("StatefulSet", "db", nil), expected: "StatefulSet/db"},
+		{pod: owned("ReplicaSet", "web-abc", map[string]string{"pod-template-hash": "abc"}), expected: "Deployment/web"},
+		{pod: owned("ReplicaSet", "standalone", nil), expected: "ReplicaSet/standalone"},
+	}
+
+	for _, tc := range testcases {
+		if owner := podOwner(tc.pod); owner != tc.expected {
+			t.Errorf("Expected %q, got %q.", tc.expected, owner)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// Segments keeps track of the currently active log segment. Segments are
+// used to split a long-running protokol session (e.g. spanning an entire
+// test suite) into multiple slices, one per test. Collectors that support
+// segmentation consult the current segment whenever they write data.
+type Segments struct {
+	lock    sync.RWMutex
+	counter int
+	current string
+}
+
+func NewSegments() *Segments {
+	return &Segments{}
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Start begins a new segment and returns its directory-safe name. Segment
+// names are prefixed with a sequence number, so that they sort in the order
+// they were created and reusing a name does not mix logs of two segments.
+func (s *Segments) Start(name string) (string, error) {
+	name = unsafeNameChars.ReplaceAllString(name, "_")
+	if name == "" || name == "." || name == ".." {
+		return "", errors.New("segment name must not be empty")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.counter++
+	s.current = fmt.Sprintf("%03d-%s", s.counter, name)
+
+	return s.current, nil
+}
+
+// Current returns the name of the current segment, or an empty string if
+// no segment was started yet. It is safe to call Current on a nil Segments.
+func (s *Segments) Current() string {
+	if s == nil {
+		return ""
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.current
+}
@@ -8,25 +8,79 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
-	"github.com/goware/prefixer"
 	"github.com/sirupsen/logrus"
 
 	corev1 "k8s.io/api/core/v1"
 )
 
 type streamCollector struct {
-	prefixFormat string
+	prefix    *prefixFormatter
+	colors    bool
+	highlight *regexp.Regexp
+	pretty    bool
+	fields    []string
+	out       *lineWriter
+	now       func() time.Time
 }
 
 var _ Collector = &streamCollector{}
 
-func NewStreamCollector(prefixFormat string) (Collector, error) {
+type StreamOptions struct {
+	// Prefix is the pattern for the prefix of each line, either using
+	// %-placeholders or a Go template that is rendered using PrefixData.
+	Prefix string
+	// Cluster is made available to prefix templates.
+	Cluster string
+	// Colors enables colouring the prefix of each line (with a stable colour
+	// per container) and highlighting lines that look like errors.
+	Colors bool
+	// Highlight are regular expressions whose matches are underlined, if
+	// colors are enabled.
+	Highlight []string
+	// Pretty enables rendering JSON and logfmt lines as "LEVEL message
+	// key=value ...". Other lines are printed unchanged.
+	Pretty bool
+	// PrettyFields are the fields to include in pretty lines besides the
+	// level and message. If empty, all fields are included.
+	PrettyFields []string
+}
+
+func NewStreamCollector(opt StreamOptions) (Collector, error) {
+	prefix, err := newPrefixFormatter(opt.Prefix, opt.Cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	var highlight *regexp.Regexp
+	if len(opt.Highlight) > 0 {
+		patterns := make([]string, 0, len(opt.Highlight))
+		for _, pattern := range opt.Highlight {
+			patterns = append(patterns, "(?:"+pattern+")")
+		}
+
+		highlight, err = regexp.Compile(strings.Join(patterns, "|"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid highlight pattern: %w", err)
+		}
+	}
+
 	return &streamCollector{
-		prefixFormat: prefixFormat,
+		prefix:    prefix,
+		colors:    opt.Colors,
+		highlight: highlight,
+		pretty:    opt.Pretty || len(opt.PrettyFields) > 0,
+		fields:    opt.PrettyFields,
+		out:       newLineWriter(os.Stdout),
+		now:       time.Now,
 	}, nil
 }
 
@@ -34,42 +88,162 @@ func (c *streamCollector) CollectEvent(ctx context.Context, event *corev1.Event)
 	return nil
 }
 
+func (c *streamCollector) CollectTimeline(ctx context.Context, pod *corev1.Pod, entries []TimelineEntry) error {
+	return nil
+}
+
+func (c *streamCollector) CollectNodeTimeline(ctx context.Context, node *corev1.Node, entries []TimelineEntry) error {
+	return nil
+}
+
 func (c *streamCollector) CollectPodMetadata(ctx context.Context, pod *corev1.Pod) error {
 	return nil
 }
 
+func (c *streamCollector) CollectIncident(ctx context.Context, incident *Incident) error {
+	return nil
+}
+
+func (c *streamCollector) Close() error {
+	return nil
+}
+
 func (c *streamCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error {
-	prefixReader := prefixer.New(stream, c.prefix(pod, containerName)+" ")
-	rd := bufio.NewReader(prefixReader)
+	var (
+		prefix string
+		err    error
+	)
 
-	for {
-		str, err := rd.ReadString('\n')
-		if errors.Is(err, io.EOF) {
-			break
-		}
+	if c.prefix.static() {
+		prefix, err = c.linePrefix(pod, containerName)
 		if err != nil {
 			return err
 		}
+	}
 
-		fmt.Println(strings.TrimSpace(str))
+	// ReadString is used instead of a Scanner, as it has no limit on the
+	// line length
+	rd := bufio.NewReader(stream)
+	parser := &lineParser{}
+
+	for {
+		str, readErr := rd.ReadString('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return readErr
+		}
+
+		// a stream can end without a trailing newline
+		if str != "" {
+			// templates can include the current time, so they have to be rendered for each line
+			if !c.prefix.static() {
+				prefix, err = c.linePrefix(pod, containerName)
+				if err != nil {
+					return err
+				}
+			}
+
+			line := strings.TrimRightFunc(str, unicode.IsSpace)
+			if c.pretty {
+				if parsed := parser.parse(line); parsed != nil {
+					line = parsed.pretty(c.fields)
+				}
+			}
+
+			if c.colors {
+				line = c.colorizeLine(line)
+			}
+
+			if err := c.out.writeLine(prefix + line); err != nil {
+				return err
+			}
+		}
+
+		if readErr != nil {
+			return nil
+		}
 	}
+}
 
-	return nil
+func (c *streamCollector) colorizeLine(line string) string {
+	if c.highlight != nil {
+		// only toggle the underline, so that an error color stays intact
+		line = c.highlight.ReplaceAllStringFunc(line, func(match string) string {
+			return "\x1b[4m" + match + "\x1b[24m"
+		})
+	}
+
+	if errorLine.MatchString(line) {
+		line = colorize(line, errorColor)
+	}
+
+	return line
 }
 
-var placeholders = regexp.MustCompile(`%([a-zA-Z]+)`)
+// linePrefix returns the (colored) prefix including the separating space,
+// or an empty string if the prefix is empty.
+func (c *streamCollector) linePrefix(pod *corev1.Pod, containerName string) (string, error) {
+	prefix, err := c.prefix.prefix(pod, containerName, c.now())
+	if err != nil || prefix == "" {
+		return "", err
+	}
 
-func (c *streamCollector) prefix(pod *corev1.Pod, containerName string) string {
-	return strings.TrimSpace(placeholders.ReplaceAllStringFunc(c.prefixFormat, func(s string) string {
-		switch s {
-		case "%pn":
-			return pod.Name
-		case "%pN":
-			return pod.Namespace
-		case "%c":
-			return containerName
-		}
+	if c.colors {
+		prefix = colorize(prefix, prefixColor(pod, containerName))
+	}
+
+	return prefix + " ", nil
+}
+
+func (c *streamCollector) CollectNodeLogs(ctx context.Context, log logrus.FieldLogger, nodeName string, logName string, stream io.Reader) error {
+	// node logs are only fetched once when stopping and are usually huge,
+	// so they are not streamed
+	_, err := io.Copy(io.Discard, stream)
+	return err
+}
+
+// lineWriter serializes the output of all concurrent log streams, so that
+// each line is written as a whole and lines of different containers do not
+// interleave.
+type lineWriter struct {
+	lock sync.Mutex
+	out  io.Writer
+}
+
+func newLineWriter(out io.Writer) *lineWriter {
+	return &lineWriter{
+		out: out,
+	}
+}
+
+func (w *lineWriter) writeLine(line string) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	_, err := io.WriteString(w.out, line+"\n")
+	return err
+}
+
+// prefixColors are the ANSI colours used for line prefixes. Red is left out,
+// as it is used to highlight errors.
+var prefixColors = []string{"32", "33", "34", "35", "36", "92", "93", "94", "95", "96"}
+
+const errorColor = "1;31"
+
+// errorLine matches log lines that most likely report an error, either by
+// containing a typical keyword or by using the klog error/fatal severity.
+// The Kubernetes log API merges stdout and stderr of a container, so the
+// stream a line was written to cannot be used.
+var errorLine = regexp.MustCompile(`(?i)\b(error|fatal|panic|exception)\b|^[EF]\d{4} `)
+
+// prefixColor returns the colour for a container, which is stable across
+// protokol invocations.
+func prefixColor(pod *corev1.Pod, containerName string) string {
+	hash := fnv.New32a()
+	fmt.Fprintf(hash, "%s/%s/%s", pod.Namespace, pod.Name, containerName)
+
+	return prefixColors[hash.Sum32()%uint32(len(prefixColors))]
+}
 
-		return s
-	}))
+func colorize(s string, color string) string {
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", color, s)
 }
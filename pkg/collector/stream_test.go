@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStreamOutput(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "my-pod"},
+	}
+
+	input := "hello world\n  indented\nE0102 03:04:05.000000 1 main.go:1] broken\n"
+	color := prefixColor(pod, "app")
+
+	testcases := []struct {
+		name     string
+		colors   bool
+		expected string
+	}{
+		{
+			name:     "plain",
+			expected: "[default/my-pod:app] >> hello world\n[default/my-pod:app] >>   indented\n[default/my-pod:app] >> E0102 03:04:05.000000 1 main.go:1] broken\n",
+		},
+		{
+			name:   "colors",
+			colors: true,
+			expected: "\x1b[" + color + "m[default/my-pod:app] >>\x1b[0m hello world\n" +
+				"\x1b[" + color + "m[default/my-pod:app] >>\x1b[0m   indented\n" +
+				"\x1b[" + color + "m[default/my-pod:app] >>\x1b[0m \x1b[1;31mE0102 03:04:05.000000 1 main.go:1] broken\x1b[0m\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			c := &streamCollector{
+				prefix: &prefixFormatter{format: "[%pN/%pn:%c] >>"},
+				colors: tc.colors,
+				out:    newLineWriter(&out),
+				now:    time.Now,
+			}
+
+			if err := c.CollectLogs(context.Background(), logrus.New(), pod, "app", strings.NewReader(input)); err != nil {
+				t.Fatalf("Failed to collect logs: %v", err)
+			}
+
+			if out.String() != tc.expected {
+				t.Fatalf("Unexpected output.\nExpected: %q\nActual:   %q", tc.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestPrefixColors(t *testing.T) {
+	a := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}}
+
+	if prefixColor(a, "app") != prefixColor(a.DeepCopy(), "app") {
+		t.Fatal("Expected the same container to always get the same color.")
+	}
+
+	colors := map[string]struct{}{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		colors[prefixColor(a, name)] = struct{}{}
+	}
+
+	if len(colors) < 2 {
+		t.Fatal("Expected different containers to get different colors.")
+	}
+}
+
+func TestErrorLines(t *testing.T) {
+	for line, expected := range map[string]bool{
+		"everything is fine":                          false,
+		"level=error msg=\"failed\"":                  true,
+		"panic: runtime error: index out of range":    true,
+		"E0102 03:04:05.000000 1 main.go:1] broken":   true,
+		"I0102 03:04:05.000000 1 main.go:1] starting": false,
+		"no errors found":                             false,
+		"java.lang.NullPointerException: oops":        false,
+		"Unhandled exception in thread main":          true,
+	} {
+		if errorLine.MatchString(line) != expected {
+			t.Errorf("Expected %q to match=%v.", line, expected)
+		}
+	}
+}
+
+// recordingWriter remembers each individual write.
+type recordingWriter struct {
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestStreamLineAtomicity(t *testing.T) {
+	prefix, err := newPrefixFormatter("{{ .Pod }}:", "")
+	if err != nil {
+		t.Fatalf("Failed to parse prefix: %v", err)
+	}
+
+	out := &recordingWriter{}
+	c := &streamCollector{
+		prefix: prefix,
+		out:    newLineWriter(out),
+		now:    time.Now,
+	}
+
+	longLine := strings.Repeat("x", 3*bufio.MaxScanTokenSize)
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}}
+			input := strings.Repeat("line\n", 100) + longLine + "\npartial"
+
+			if err := c.CollectLogs(context.Background(), logrus.New(), pod, "app", strings.NewReader(input)); err != nil {
+				t.Errorf("Failed to collect logs: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	counts := map[string]int{}
+	for _, write := range out.writes {
+		counts[write]++
+	}
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		for line, expected := range map[string]int{
+			name + ": line\n":             100,
+			name + ": " + longLine + "\n": 1,
+			name + ": partial\n":          1,
+		} {
+			if counts[line] != expected {
+				t.Errorf("Expected %d writes of %.20q…, got %d.", expected, line, counts[line])
+			}
+		}
+	}
+
+	if len(out.writes) != 4*102 {
+		t.Errorf("Expected %d writes, got %d.", 4*102, len(out.writes))
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	coll, err := NewStreamCollector(StreamOptions{Colors: true, Highlight: []string{"foo", "ba+r"}})
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	c := coll.(*streamCollector)
+
+	expected := "a \x1b[4mfoo\x1b[24m and a \x1b[4mbaaar\x1b[24m"
+	if line := c.colorizeLine("a foo and a baaar"); line != expected {
+		t.Fatalf("Expected %q, got %q.", expected, line)
+	}
+
+	expected = "\x1b[1;31merror: \x1b[4mfoo\x1b[24m\x1b[0m"
+	if line := c.colorizeLine("error: foo"); line != expected {
+		t.Fatalf("Expected %q, got %q.", expected, line)
+	}
+}
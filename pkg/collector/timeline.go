@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimelineEntry is a single lifecycle transition of a pod or one of its
+// containers, as observed by the watcher.
+type TimelineEntry struct {
+	Time time.Time `json:"time"`
+	// Type is the kind of transition, e.g. "Condition" or "ContainerTerminated".
+	Type string `json:"type"`
+	// Container is empty for transitions of the pod itself.
+	Container string `json:"container,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Message   string `json:"message,omitempty"`
+	ExitCode  *int32 `json:"exitCode,omitempty"`
+}
+
+func (e TimelineEntry) String() string {
+	stringified := fmt.Sprintf("%s: %s", e.Time.Format(time.RFC1123), e.Type)
+
+	if e.Container != "" {
+		stringified = fmt.Sprintf("%s [%s]", stringified, e.Container)
+	}
+
+	var details []string
+	if e.Reason != "" {
+		details = append(details, fmt.Sprintf("reason: %s", e.Reason))
+	}
+
+	if e.ExitCode != nil {
+		details = append(details, fmt.Sprintf("exit code: %d", *e.ExitCode))
+	}
+
+	if len(details) > 0 {
+		stringified = fmt.Sprintf("%s (%s)", stringified, strings.Join(details, ", "))
+	}
+
+	if e.Message != "" {
+		stringified = fmt.Sprintf("%s %s", stringified, e.Message)
+	}
+
+	return stringified
+}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+// Package control implements a small HTTP API on a Unix socket, which can be
+// used to control a running protokol process (e.g. to start new log segments
+// from within a test suite).
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"go.xrstf.de/protokol/pkg/collector"
+)
+
+const markPath = "/mark"
+
+type Server struct {
+	socketPath string
+	segments   *collector.Segments
+	log        logrus.FieldLogger
+}
+
+func NewServer(socketPath string, segments *collector.Segments, log logrus.FieldLogger) *Server {
+	return &Server{
+		socketPath: socketPath,
+		segments:   segments,
+		log:        log,
+	}
+}
+
+// Listen opens the Unix socket and serves requests in the background until
+// the context is cancelled. The socket file is removed when the server stops.
+func (s *Server) Listen(ctx context.Context) error {
+	// remove leftovers from previous runs
+	if err := os.Remove(s.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket %q: %w", s.socketPath, err)
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %w", s.socketPath, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(markPath, s.handleMark)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.WithError(err).Error("Control server failed.")
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_ = server.Shutdown(shutdownCtx)
+		_ = os.Remove(s.socketPath)
+	}()
+
+	return nil
+}
+
+func (s *Server) handleMark(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	segment, err := s.segments.Start(r.FormValue("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.log.WithField("segment", segment).Info("Starting new segment.")
+
+	fmt.Fprintln(w, segment)
+}
+
+// Mark connects to a running protokol process via its control socket and
+// tells it to start a new segment. The final segment name is returned.
+func Mark(ctx context.Context, socketPath string, name string) (string, error) {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	body := url.Values{"name": []string{name}}.Encode()
+
+	// the hostname is irrelevant, as the connection is always made to the socket
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://protokol"+markPath, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to contact protokol: %w", err)
+	}
+	defer resp.Body.Close()
+
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("protokol responded with %s: %s", resp.Status, strings.TrimSpace(string(response)))
+	}
+
+	return strings.TrimSpace(string(response)), nil
+}
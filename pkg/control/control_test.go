@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package control
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"go.xrstf.de/protokol/pkg/collector"
+)
+
+func TestMark(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	segments := collector.NewSegments()
+	socket := filepath.Join(t.TempDir(), "protokol.sock")
+
+	if err := NewServer(socket, segments, log).Listen(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	testcases := []struct {
+		name     string
+		expected string
+		invalid  bool
+	}{
+		{name: "TestFoo", expected: "001-TestFoo"},
+		{name: "TestFoo/sub test", expected: "002-TestFoo_sub_test"},
+		{name: "..", invalid: true},
+		{name: "", invalid: true},
+		{name: "TestFoo", expected: "003-TestFoo"},
+	}
+
+	for _, tc := range testcases {
+		segment, err := Mark(ctx, socket, tc.name)
+		if tc.invalid {
+			if err == nil {
+				t.Fatalf("Expected error for %q, but got segment %q.", tc.name, segment)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("Failed to mark %q: %v", tc.name, err)
+		}
+
+		if segment != tc.expected {
+			t.Fatalf("Expected segment %q, got %q.", tc.expected, segment)
+		}
+
+		if current := segments.Current(); current != tc.expected {
+			t.Fatalf("Expected current segment to be %q, got %q.", tc.expected, current)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package trigger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Match describes a log line that matched a trigger. It is sent as the
+// payload of webhooks.
+type Match struct {
+	Trigger   string    `json:"trigger"`
+	Namespace string    `json:"namespace"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Line      string    `json:"line"`
+	Time      time.Time `json:"time"`
+}
+
+// Snapshotter captures the current state of a namespace.
+type Snapshotter interface {
+	Snapshot(ctx context.Context, namespace string) ([]corev1.Pod, []corev1.Event, error)
+}
+
+type clientsetSnapshotter struct {
+	clientset kubernetes.Interface
+}
+
+// NewSnapshotter returns a Snapshotter that lists pods and events using the
+// given clientset.
+func NewSnapshotter(clientset kubernetes.Interface) Snapshotter {
+	return &clientsetSnapshotter{
+		clientset: clientset,
+	}
+}
+
+func (s *clientsetSnapshotter) Snapshot(ctx context.Context, namespace string) ([]corev1.Pod, []corev1.Event, error) {
+	pods, err := s.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	events, err := s.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	return pods.Items, events.Items, nil
+}
+
+// run executes all actions of the trigger and returns all errors that
+// occurred.
+func (c *triggerCollector) run(ctx context.Context, trigger *Trigger, match Match) error {
+	var errs []error
+
+	if len(trigger.Exec) > 0 {
+		if err := runCommand(ctx, trigger.Exec, match); err != nil {
+			errs = append(errs, fmt.Errorf("command failed: %w", err))
+		}
+	}
+
+	if trigger.Webhook != "" {
+		if err := c.callWebhook(ctx, trigger.Webhook, match); err != nil {
+			errs = append(errs, fmt.Errorf("webhook failed: %w", err))
+		}
+	}
+
+	if trigger.MarkerFile != "" {
+		if err := writeMarker(trigger.MarkerFile, match); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write marker file: %w", err))
+		}
+	}
+
+	if trigger.Snapshot {
+		if err := c.snapshot(ctx, match); err != nil {
+			errs = append(errs, fmt.Errorf("failed to capture snapshot: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func runCommand(ctx context.Context, command []string, match Match) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"PROTOKOL_TRIGGER="+match.Trigger,
+		"PROTOKOL_NAMESPACE="+match.Namespace,
+		"PROTOKOL_POD="+match.Pod,
+		"PROTOKOL_CONTAINER="+match.Container,
+		"PROTOKOL_LINE="+match.Line,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func (c *triggerCollector) callWebhook(ctx context.Context, url string, match Match) error {
+	payload, err := json.Marshal(match)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("server responded with %s", resp.Status)
+	}
+
+	return nil
+}
+
+func writeMarker(filename string, match Match) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s %s %s/%s/%s: %s\n", match.Time.Format(time.RFC3339), match.Trigger, match.Namespace, match.Pod, match.Container, match.Line)
+
+	return err
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// snapshot writes all pods and events of the namespace into a new directory
+// named after the time, trigger, pod and container of the match.
+func (c *triggerCollector) snapshot(ctx context.Context, match Match) error {
+	if c.snapshotter == nil {
+		return errors.New("snapshots are not enabled")
+	}
+
+	pods, events, err := c.snapshotter.Snapshot(ctx, match.Namespace)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s-%s-%s", match.Time.Format("20060102T150405"), match.Trigger, match.Pod, match.Container)
+
+	directory, err := createUniqueDirectory(c.snapshotDirectory, unsafeNameChars.ReplaceAllString(name, "_"))
+	if err != nil {
+		return err
+	}
+
+	var podDocs []any
+	for i := range pods {
+		pod := &pods[i]
+		if c.redactor != nil {
+			pod = c.redactor.RedactPod(pod)
+		}
+
+		pod.APIVersion = "v1"
+		pod.Kind = "Pod"
+		pod.ManagedFields = nil
+		podDocs = append(podDocs, pod)
+	}
+
+	var eventDocs []any
+	for i := range events {
+		event := &events[i]
+		if c.redactor != nil {
+			event.Message = c.redactor.Redact(event.Message)
+		}
+
+		event.APIVersion = "v1"
+		event.Kind = "Event"
+		event.ManagedFields = nil
+		eventDocs = append(eventDocs, event)
+	}
+
+	if err := writeYAMLDocuments(filepath.Join(directory, "pods.yaml"), podDocs); err != nil {
+		return err
+	}
+
+	return writeYAMLDocuments(filepath.Join(directory, "events.yaml"), eventDocs)
+}
+
+// createUniqueDirectory creates a new directory with the given name. If it
+// exists already (e.g. because the trigger has no cooldown), a counter is
+// appended to the name.
+func createUniqueDirectory(parent string, name string) (string, error) {
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+
+	directory := filepath.Join(parent, name)
+
+	for i := 2; ; i++ {
+		err := os.Mkdir(directory, 0755)
+		if err == nil {
+			return directory, nil
+		}
+
+		if !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+
+		directory = filepath.Join(parent, fmt.Sprintf("%s~%d", name, i))
+	}
+}
+
+func writeYAMLDocuments(filename string, docs []any) error {
+	var buf bytes.Buffer
+
+	for _, doc := range docs {
+		encoded, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+
+		buf.WriteString("---\n")
+		buf.Write(encoded)
+	}
+
+	return os.WriteFile(filename, buf.Bytes(), 0644)
+}
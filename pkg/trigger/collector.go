@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package trigger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"go.xrstf.de/protokol/pkg/collector"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// actionTimeout is how long all actions of a single match may take.
+const actionTimeout = 30 * time.Second
+
+type Options struct {
+	// Snapshotter is required for triggers with snapshots.
+	Snapshotter Snapshotter
+	// SnapshotDirectory is where snapshots are stored.
+	SnapshotDirectory string
+	// Redactor is optional; if given, it is applied to the pods and event
+	// messages in snapshots, which do not pass through the collectors.
+	Redactor *collector.Redactor
+}
+
+type triggerCollector struct {
+	inner             collector.Collector
+	triggers          []Trigger
+	snapshotter       Snapshotter
+	snapshotDirectory string
+	redactor          *collector.Redactor
+	httpClient        *http.Client
+	now               func() time.Time
+
+	lock sync.Mutex
+	// lastRun remembers when a trigger was last executed for a container.
+	lastRun map[string]time.Time
+	running sync.WaitGroup
+}
+
+var _ collector.Collector = &triggerCollector{}
+
+// NewCollector wraps a Collector and evaluates the triggers for each log
+// line before passing it on unchanged. Actions run in the background, so
+// they do not delay the logs.
+func NewCollector(inner collector.Collector, triggers []Trigger, opt Options) collector.Collector {
+	return &triggerCollector{
+		inner:             inner,
+		triggers:          triggers,
+		snapshotter:       opt.Snapshotter,
+		snapshotDirectory: opt.SnapshotDirectory,
+		redactor:          opt.Redactor,
+		httpClient:        &http.Client{},
+		now:               time.Now,
+		lastRun:           map[string]time.Time{},
+	}
+}
+
+func (c *triggerCollector) CollectEvent(ctx context.Context, event *corev1.Event) error {
+	return c.inner.CollectEvent(ctx, event)
+}
+
+func (c *triggerCollector) CollectTimeline(ctx context.Context, pod *corev1.Pod, entries []collector.TimelineEntry) error {
+	return c.inner.CollectTimeline(ctx, pod, entries)
+}
+
+func (c *triggerCollector) CollectNodeTimeline(ctx context.Context, node *corev1.Node, entries []collector.TimelineEntry) error {
+	return c.inner.CollectNodeTimeline(ctx, node, entries)
+}
+
+func (c *triggerCollector) CollectPodMetadata(ctx context.Context, pod *corev1.Pod) error {
+	return c.inner.CollectPodMetadata(ctx, pod)
+}
+
+func (c *triggerCollector) CollectIncident(ctx context.Context, incident *collector.Incident) error {
+	return c.inner.CollectIncident(ctx, incident)
+}
+
+func (c *triggerCollector) CollectNodeLogs(ctx context.Context, log logrus.FieldLogger, nodeName string, logName string, stream io.Reader) error {
+	return c.inner.CollectNodeLogs(ctx, log, nodeName, logName, stream)
+}
+
+// Close waits for all running actions to finish.
+func (c *triggerCollector) Close() error {
+	c.running.Wait()
+
+	return c.inner.Close()
+}
+
+func (c *triggerCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error {
+	var triggers []*Trigger
+	for i, trigger := range c.triggers {
+		if trigger.appliesTo(pod.Namespace, pod.Name, containerName) {
+			triggers = append(triggers, &c.triggers[i])
+		}
+	}
+
+	if len(triggers) > 0 {
+		stream = collector.NewLineReader(stream, func(line string) string {
+			content := strings.TrimRight(line, "\r\n")
+
+			for _, trigger := range triggers {
+				if trigger.pattern.MatchString(content) {
+					c.fire(ctx, log, trigger, pod, containerName, content)
+				}
+			}
+
+			return line
+		})
+	}
+
+	return c.inner.CollectLogs(ctx, log, pod, containerName, stream)
+}
+
+func (c *triggerCollector) fire(ctx context.Context, log logrus.FieldLogger, trigger *Trigger, pod *corev1.Pod, containerName string, line string) {
+	now := c.now()
+	key := fmt.Sprintf("%s/%s/%s/%s", trigger.Name, pod.Namespace, pod.Name, containerName)
+
+	c.lock.Lock()
+	if last, exists := c.lastRun[key]; exists && now.Sub(last) < trigger.cooldown() {
+		c.lock.Unlock()
+		return
+	}
+	c.lastRun[key] = now
+	c.lock.Unlock()
+
+	match := Match{
+		Trigger:   trigger.Name,
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Container: containerName,
+		Line:      line,
+		Time:      now,
+	}
+
+	log = log.WithField("trigger", trigger.Name)
+	log.Info("Log line matched trigger.")
+
+	c.running.Add(1)
+	go func() {
+		defer c.running.Done()
+
+		// actions must finish even if the log stream ends
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), actionTimeout)
+		defer cancel()
+
+		if err := c.run(ctx, trigger, match); err != nil {
+			log.WithError(err).Error("Trigger action failed.")
+		}
+	}()
+}
@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package trigger
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"go.xrstf.de/protokol/pkg/collector"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// logCollector records the logs it receives.
+type logCollector struct {
+	collector.Collector
+
+	logs string
+}
+
+func (c *logCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error {
+	content, err := io.ReadAll(stream)
+	c.logs = string(content)
+
+	return err
+}
+
+func (c *logCollector) Close() error {
+	return nil
+}
+
+func newTestPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "default",
+		},
+	}
+}
+
+func TestMarkerFileAndCooldown(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "marker")
+	triggers := []Trigger{
+		{Name: "panic", pattern: regexp.MustCompile(`^panic:`), MarkerFile: marker},
+		{Name: "ignored", pattern: regexp.MustCompile(`.`), Container: "sidecar", MarkerFile: marker},
+	}
+
+	inner := &logCollector{}
+	coll := NewCollector(inner, triggers, Options{})
+
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	coll.(*triggerCollector).now = func() time.Time { return now }
+
+	// the second panic is within the cooldown
+	input := "starting\npanic: oh no\npanic: again\n"
+
+	if err := coll.CollectLogs(context.Background(), logrus.New(), newTestPod(), "app", strings.NewReader(input)); err != nil {
+		t.Fatalf("Failed to collect logs: %v", err)
+	}
+
+	if err := coll.Close(); err != nil {
+		t.Fatalf("Failed to close collector: %v", err)
+	}
+
+	if inner.logs != input {
+		t.Errorf("Expected logs to be passed on unchanged, got %q.", inner.logs)
+	}
+
+	content, err := os.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("Failed to read marker file: %v", err)
+	}
+
+	expected := "2023-01-02T03:04:05Z panic default/web/app: panic: oh no\n"
+	if string(content) != expected {
+		t.Fatalf("Expected marker file to contain %q, got %q.", expected, string(content))
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		received []Match
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		match := Match{}
+		if err := json.NewDecoder(r.Body).Decode(&match); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		lock.Lock()
+		received = append(received, match)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	triggers := []Trigger{
+		{Name: "oom", pattern: regexp.MustCompile(`out of memory`), Webhook: server.URL},
+	}
+
+	coll := NewCollector(&logCollector{}, triggers, Options{})
+
+	if err := coll.CollectLogs(context.Background(), logrus.New(), newTestPod(), "app", strings.NewReader("fatal: out of memory")); err != nil {
+		t.Fatalf("Failed to collect logs: %v", err)
+	}
+
+	if err := coll.Close(); err != nil {
+		t.Fatalf("Failed to close collector: %v", err)
+	}
+
+	if len(received) != 1 {
+		t.Fatalf("Expected 1 webhook call, got %d.", len(received))
+	}
+
+	if match := received[0]; match.Trigger != "oom" || match.Pod != "web" || match.Line != "fatal: out of memory" {
+		t.Fatalf("Received unexpected payload: %+v", match)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	pod := newTestPod()
+	pod.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}
+	pod.Spec.Containers = []corev1.Container{{
+		Name: "app",
+		Env:  []corev1.EnvVar{{Name: "DB_PASSWORD", Value: "hunter2"}},
+	}}
+
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "web.1234", Namespace: "default"},
+		Reason:     "BackOff",
+		Message:    "connecting with password=hunter2 failed",
+	}
+
+	redactor, err := collector.NewRedactor(collector.RedactOptions{Patterns: collector.DefaultRedactionPatterns})
+	if err != nil {
+		t.Fatalf("Failed to create redactor: %v", err)
+	}
+
+	directory := t.TempDir()
+	triggers := []Trigger{
+		{Name: "crash", pattern: regexp.MustCompile(`crash`), Snapshot: true, Cooldown: &metav1.Duration{}},
+	}
+
+	coll := NewCollector(&logCollector{}, triggers, Options{
+		Snapshotter:       NewSnapshotter(fake.NewSimpleClientset(pod, event)),
+		SnapshotDirectory: directory,
+		Redactor:          redactor,
+	})
+
+	// all matches happen within the same second
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	coll.(*triggerCollector).now = func() time.Time { return now }
+
+	for _, container := range []string{"app", "sidecar", "app"} {
+		if err := coll.CollectLogs(context.Background(), logrus.New(), pod, container, strings.NewReader("about to crash\n")); err != nil {
+			t.Fatalf("Failed to collect logs: %v", err)
+		}
+	}
+
+	if err := coll.Close(); err != nil {
+		t.Fatalf("Failed to close collector: %v", err)
+	}
+
+	for _, name := range []string{"20230102T030405-crash-web-app~2", "20230102T030405-crash-web-sidecar"} {
+		if _, err := os.Stat(filepath.Join(directory, name)); err != nil {
+			t.Errorf("Expected snapshot %s to exist: %v", name, err)
+		}
+	}
+
+	snapshots, err := filepath.Glob(filepath.Join(directory, "*-crash-web-*"))
+	if err != nil || len(snapshots) != 3 {
+		t.Fatalf("Expected three snapshot directories, got %v.", snapshots)
+	}
+
+	for filename, expected := range map[string]string{
+		"pods.yaml":   "kind: Pod",
+		"events.yaml": "reason: BackOff",
+	} {
+		content, err := os.ReadFile(filepath.Join(snapshots[0], filename))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filename, err)
+		}
+
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Expected %s to contain %q, but it did not:\n%s", filename, expected, string(content))
+		}
+
+		for _, unexpected := range []string{"hunter2", "managedFields"} {
+			if strings.Contains(string(content), unexpected) {
+				t.Errorf("Expected %s not to contain %q, but it did:\n%s", filename, unexpected, string(content))
+			}
+		}
+	}
+}
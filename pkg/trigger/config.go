@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+// Package trigger implements actions that are executed as soon as a log
+// line matches a configured pattern, e.g. to send an alert when a container
+// panics.
+package trigger
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the content of a trigger configuration file.
+type Config struct {
+	Triggers []Trigger `json:"triggers"`
+}
+
+// Trigger defines a pattern and the actions to execute when a log line
+// matches it. Each action is optional, but at least one must be configured.
+type Trigger struct {
+	// Name identifies the trigger in logs, snapshots and action payloads.
+	Name string `json:"name"`
+	// Pattern is the regular expression that is matched against each line.
+	Pattern string `json:"pattern"`
+	// Namespace, Pod and Container optionally limit the trigger to certain
+	// containers (supports glob expressions).
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	// Cooldown is the minimum time between two executions of this trigger
+	// for the same container, so that a burst of matching lines does not
+	// result in a burst of actions. Defaults to 10s.
+	Cooldown *metav1.Duration `json:"cooldown,omitempty"`
+
+	// Exec is a command (and its arguments) to execute. Details about the
+	// match are available as PROTOKOL_* environment variables.
+	Exec []string `json:"exec,omitempty"`
+	// Webhook is a URL to send a POST request with a JSON payload to.
+	Webhook string `json:"webhook,omitempty"`
+	// MarkerFile is a file to append a line about the match to.
+	MarkerFile string `json:"markerFile,omitempty"`
+	// Snapshot enables capturing all pods and events in the namespace of
+	// the matching container.
+	Snapshot bool `json:"snapshot,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+const defaultCooldown = 10 * time.Second
+
+// LoadConfig reads and validates a trigger configuration file.
+func LoadConfig(filename string) ([]Trigger, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{}
+	if err := yaml.UnmarshalStrict(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", filename, err)
+	}
+
+	for i := range config.Triggers {
+		if err := config.Triggers[i].compile(); err != nil {
+			return nil, fmt.Errorf("invalid trigger %d: %w", i+1, err)
+		}
+	}
+
+	return config.Triggers, nil
+}
+
+func (t *Trigger) compile() error {
+	if t.Name == "" {
+		return errors.New("no name configured")
+	}
+
+	if t.Pattern == "" {
+		return errors.New("no pattern configured")
+	}
+
+	if len(t.Exec) == 0 && t.Webhook == "" && t.MarkerFile == "" && !t.Snapshot {
+		return errors.New("no action configured")
+	}
+
+	pattern, err := regexp.Compile(t.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	t.pattern = pattern
+
+	return nil
+}
+
+func (t *Trigger) cooldown() time.Duration {
+	if t.Cooldown == nil {
+		return defaultCooldown
+	}
+
+	return t.Cooldown.Duration
+}
+
+// appliesTo returns true if the trigger is scoped to the given container.
+func (t *Trigger) appliesTo(namespace string, podName string, containerName string) bool {
+	return nameMatches(namespace, t.Namespace) && nameMatches(podName, t.Pod) && nameMatches(containerName, t.Container)
+}
+
+func nameMatches(name string, pattern string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	if strings.Contains(pattern, "*") {
+		matched, _ := filepath.Match(pattern, name)
+		return matched
+	}
+
+	return name == pattern
+}
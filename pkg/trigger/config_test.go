@@ -0,0 +1,97 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package trigger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	testcases := []struct {
+		name    string
+		config  string
+		invalid bool
+	}{
+		{
+			name: "valid trigger",
+			config: `
+triggers:
+  - name: panic
+    pattern: "^panic:"
+    container: "app-*"
+    cooldown: 1m
+    markerFile: /tmp/panics
+`,
+		},
+		{
+			name: "no action",
+			config: `
+triggers:
+  - name: panic
+    pattern: "^panic:"
+`,
+			invalid: true,
+		},
+		{
+			name: "invalid pattern",
+			config: `
+triggers:
+  - name: panic
+    pattern: "(unclosed"
+    snapshot: true
+`,
+			invalid: true,
+		},
+		{
+			name: "unknown field",
+			config: `
+triggers:
+  - name: panic
+    pattern: "^panic:"
+    snapshot: true
+    unknown: true
+`,
+			invalid: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "triggers.yaml")
+			if err := os.WriteFile(filename, []byte(tc.config), 0644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			triggers, err := LoadConfig(filename)
+			if tc.invalid {
+				if err == nil {
+					t.Fatal("Expected config to be invalid, but it was accepted.")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+
+			trigger := triggers[0]
+
+			if trigger.cooldown() != time.Minute {
+				t.Errorf("Expected cooldown of 1m, got %v.", trigger.cooldown())
+			}
+
+			if !trigger.appliesTo("default", "web", "app-server") {
+				t.Error("Expected trigger to apply to app-server container.")
+			}
+
+			if trigger.appliesTo("default", "web", "sidecar") {
+				t.Error("Expected trigger not to apply to sidecar container.")
+			}
+		})
+	}
+}
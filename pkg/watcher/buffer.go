@@ -0,0 +1,288 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// BufferPolicy decides what happens when the buffer of a log stream is full.
+type BufferPolicy string
+
+const (
+	// BufferBlock stops reading from the log stream until the collectors
+	// have caught up.
+	BufferBlock BufferPolicy = "block"
+	// BufferDropOldest discards the oldest buffered line for each new line.
+	BufferDropOldest BufferPolicy = "drop-oldest"
+	// BufferSpill writes all further lines into a temporary file until the
+	// collectors have caught up.
+	BufferSpill BufferPolicy = "spill"
+)
+
+type BufferOptions struct {
+	// Lines is the number of lines that are buffered in memory for each log
+	// stream. If 0, collectors read directly from the log streams.
+	Lines int
+	// Policy is BufferBlock if empty.
+	Policy BufferPolicy
+	// SpillDirectory is where temporary files are created with BufferSpill,
+	// os.TempDir() if empty.
+	SpillDirectory string
+}
+
+// lineBuffer reads a log stream as fast as possible into a bounded buffer,
+// so that slow collectors do not slow down the stream itself. It is safe
+// for one reader and the internal pump to use it concurrently.
+type lineBuffer struct {
+	opt  BufferOptions
+	done chan struct{}
+
+	lock  sync.Mutex
+	cond  *sync.Cond
+	lines [][]byte
+	// current is the unread rest of the line that is currently being read.
+	current []byte
+	// readerWaiting is true while the reader waits for new lines.
+	readerWaiting bool
+	// spillFile holds the lines that did not fit into memory; everything
+	// between the read and write offsets has not been read yet.
+	spillFile  *os.File
+	spillRead  int64
+	spillWrite int64
+	spillErr   error
+	spilled    int
+	dropped    int
+	closed     bool
+	err        error
+	stopped    bool
+}
+
+func newLineBuffer(source io.Reader, opt BufferOptions) *lineBuffer {
+	if opt.Policy == "" {
+		opt.Policy = BufferBlock
+	}
+
+	b := &lineBuffer{
+		opt:  opt,
+		done: make(chan struct{}),
+	}
+	b.cond = sync.NewCond(&b.lock)
+
+	go b.pump(source)
+
+	return b
+}
+
+// maxLineLength is the length after which overly long lines are split, so
+// that a single line cannot exhaust the memory.
+const maxLineLength = 64 * 1024
+
+func (b *lineBuffer) pump(source io.Reader) {
+	defer close(b.done)
+
+	reader := bufio.NewReaderSize(source, maxLineLength)
+
+	for {
+		line, err := reader.ReadSlice('\n')
+		if len(line) > 0 && !b.push(bytes.Clone(line)) {
+			return
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+
+			b.lock.Lock()
+			b.closed = true
+			b.err = err
+			b.cond.Broadcast()
+			b.lock.Unlock()
+
+			return
+		}
+	}
+}
+
+// push adds a line to the buffer according to the policy and returns false
+// once the reader has stopped reading.
+func (b *lineBuffer) push(line []byte) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// a reader that waits for new lines is not slow, it just has not been
+	// scheduled since they arrived, so lines are only dropped or spilled
+	// while the reader is busy elsewhere
+	for len(b.lines) >= b.opt.Lines && (b.opt.Policy == BufferBlock || b.readerWaiting) && !b.stopped {
+		b.cond.Wait()
+	}
+
+	if b.stopped {
+		return false
+	}
+
+	defer b.cond.Broadcast()
+
+	// once lines were spilled, all following lines must be spilled as well
+	// to keep their order
+	if len(b.lines) < b.opt.Lines && b.spillWrite == b.spillRead {
+		b.lines = append(b.lines, line)
+		return true
+	}
+
+	switch b.opt.Policy {
+	case BufferDropOldest:
+		b.lines[0] = nil
+		b.lines = append(b.lines[1:], line)
+		b.dropped++
+
+	case BufferSpill:
+		if err := b.spill(line); err != nil {
+			b.spillErr = err
+			b.dropped++
+		} else {
+			b.spilled++
+		}
+	}
+
+	return true
+}
+
+func (b *lineBuffer) spill(line []byte) error {
+	if b.spillFile == nil {
+		f, err := os.CreateTemp(b.opt.SpillDirectory, "protokol-*.spill")
+		if err != nil {
+			return fmt.Errorf("failed to create spill file: %w", err)
+		}
+
+		b.spillFile = f
+	}
+
+	n, err := b.spillFile.WriteAt(line, b.spillWrite)
+	b.spillWrite += int64(n)
+
+	return err
+}
+
+// Read fills p with as many buffered lines as fit, so that a fast reader
+// empties the buffer quickly and rarely has to wait for the lock.
+func (b *lineBuffer) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for len(b.current) == 0 && len(b.lines) == 0 && b.spillRead == b.spillWrite && !b.closed {
+		b.readerWaiting = true
+		b.cond.Wait()
+	}
+
+	b.readerWaiting = false
+
+	n := 0
+
+	for n < len(p) {
+		if len(b.current) == 0 {
+			if len(b.lines) == 0 {
+				break
+			}
+
+			b.current = b.lines[0]
+			b.lines[0] = nil
+			b.lines = b.lines[1:]
+		}
+
+		copied := copy(p[n:], b.current)
+		b.current = b.current[copied:]
+		n += copied
+	}
+
+	// spilled lines are always newer than the lines in memory
+	if n < len(p) && len(b.current) == 0 && b.spillRead < b.spillWrite {
+		spilled, err := b.readSpilled(p[n:])
+		n += spilled
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if n > 0 {
+		// a blocked pump can continue now
+		b.cond.Broadcast()
+		return n, nil
+	}
+
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	return 0, io.EOF
+}
+
+func (b *lineBuffer) readSpilled(p []byte) (int, error) {
+	if remaining := b.spillWrite - b.spillRead; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := b.spillFile.ReadAt(p, b.spillRead)
+	b.spillRead += int64(n)
+
+	// reuse the file once everything has been read
+	if b.spillRead == b.spillWrite {
+		b.spillRead, b.spillWrite = 0, 0
+		if err := b.spillFile.Truncate(0); err != nil {
+			b.spillErr = err
+		}
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// stop discards all buffered lines and makes the pump stop once its next
+// read from the log stream returns, which requires the stream to either
+// produce more data or to be closed.
+func (b *lineBuffer) stop() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.stopped = true
+	b.lines = nil
+	b.cond.Broadcast()
+}
+
+// wait waits for the pump to finish, removes the spill file and returns the
+// number of dropped and spilled lines.
+func (b *lineBuffer) wait() (dropped int, spilled int, err error) {
+	<-b.done
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	err = b.spillErr
+
+	if b.spillFile != nil {
+		err = errors.Join(err, b.spillFile.Close(), os.Remove(b.spillFile.Name()))
+		b.spillFile = nil
+	}
+
+	return b.dropped, b.spilled, err
+}
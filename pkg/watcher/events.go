@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// EventFromEventsV1 converts an events.k8s.io/v1 Event into the core/v1
+// representation that is used by all collectors. Both APIs serve the same
+// objects, so no information is lost.
+func EventFromEventsV1(event *eventsv1.Event) *corev1.Event {
+	converted := &corev1.Event{
+		ObjectMeta:          *event.ObjectMeta.DeepCopy(),
+		InvolvedObject:      event.Regarding,
+		Related:             event.Related,
+		Reason:              event.Reason,
+		Message:             event.Note,
+		Source:              event.DeprecatedSource,
+		FirstTimestamp:      event.DeprecatedFirstTimestamp,
+		LastTimestamp:       event.DeprecatedLastTimestamp,
+		Count:               event.DeprecatedCount,
+		Type:                event.Type,
+		EventTime:           event.EventTime,
+		Action:              event.Action,
+		ReportingController: event.ReportingController,
+		ReportingInstance:   event.ReportingInstance,
+	}
+
+	converted.APIVersion = "v1"
+	converted.Kind = "Event"
+
+	if event.Series != nil {
+		converted.Series = &corev1.EventSeries{
+			Count:            event.Series.Count,
+			LastObservedTime: event.Series.LastObservedTime,
+		}
+	}
+
+	return converted
+}
+
+// eventFromUnstructured decodes an Event from either of the two event APIs.
+func eventFromUnstructured(obj *unstructured.Unstructured) (*corev1.Event, error) {
+	if obj.GetAPIVersion() == eventsv1.SchemeGroupVersion.String() {
+		event := &eventsv1.Event{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), event); err != nil {
+			return nil, err
+		}
+
+		return EventFromEventsV1(event), nil
+	}
+
+	event := &corev1.Event{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), event); err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
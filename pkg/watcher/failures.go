@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ContainerFailure describes a matched container that has crashed during
+// the run.
+type ContainerFailure struct {
+	Namespace string
+	Pod       string
+	Container string
+	// ExitCode is the last non-zero exit code that was observed, or 0 if the
+	// container never exited with an error (e.g. it only restarted too often).
+	ExitCode int32
+	// Reason is the termination reason that belongs to the ExitCode, e.g.
+	// "OOMKilled" or "Error".
+	Reason string
+	// Restarts is the number of restarts observed during the run.
+	Restarts int32
+}
+
+type containerHistory struct {
+	failure         ContainerFailure
+	initialRestarts int32
+	crashed         bool
+}
+
+// failureTracker records crashes of matched containers based on the
+// container statuses of each pod update.
+type failureTracker struct {
+	maxRestarts int32
+	// since is when the watch started; earlier terminations are ignored.
+	since      time.Time
+	containers map[string]*containerHistory
+}
+
+func newFailureTracker(maxRestarts int, since time.Time) *failureTracker {
+	return &failureTracker{
+		maxRestarts: int32(maxRestarts),
+		since:       since,
+		containers:  map[string]*containerHistory{},
+	}
+}
+
+func (t *failureTracker) update(pod *corev1.Pod, statuses []corev1.ContainerStatus) {
+	for _, status := range statuses {
+		key := fmt.Sprintf("%s/%s", podKey(pod), status.Name)
+
+		history, exists := t.containers[key]
+		if !exists {
+			history = &containerHistory{
+				failure: ContainerFailure{
+					Namespace: pod.Namespace,
+					Pod:       pod.Name,
+					Container: status.Name,
+				},
+				initialRestarts: status.RestartCount,
+			}
+			t.containers[key] = history
+		}
+
+		history.failure.Restarts = status.RestartCount - history.initialRestarts
+
+		// the previous incarnation might have crashed, even if the container is now running again
+		for _, state := range []*corev1.ContainerStateTerminated{status.LastTerminationState.Terminated, status.State.Terminated} {
+			if state != nil && (state.ExitCode != 0 || state.Reason == "OOMKilled") && !t.before(state) {
+				history.crashed = true
+				history.failure.ExitCode = state.ExitCode
+				history.failure.Reason = state.Reason
+			}
+		}
+	}
+}
+
+// before returns true if the termination is known to have happened before
+// the watch started. Terminations without a time are always considered.
+func (t *failureTracker) before(state *corev1.ContainerStateTerminated) bool {
+	return !state.FinishedAt.IsZero() && state.FinishedAt.Time.Before(t.since)
+}
+
+// forget removes all containers of the given pod that have not failed, so
+// that the tracker does not grow indefinitely on long runs.
+func (t *failureTracker) forget(pod *corev1.Pod) {
+	prefix := podKey(pod) + "/"
+
+	for key, history := range t.containers {
+		if strings.HasPrefix(key, prefix) && !history.crashed && history.failure.Restarts <= t.maxRestarts {
+			delete(t.containers, key)
+		}
+	}
+}
+
+// failures returns all containers that crashed or restarted more often than
+// allowed, sorted by namespace, pod and container name.
+func (t *failureTracker) failures() []ContainerFailure {
+	var result []ContainerFailure
+
+	for _, history := range t.containers {
+		if history.crashed || history.failure.Restarts > t.maxRestarts {
+			result = append(result, history.failure)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+
+		if a.Pod != b.Pod {
+			return a.Pod < b.Pod
+		}
+
+		return a.Container < b.Container
+	})
+
+	return result
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// activityTracker remembers when the last log bytes or Kubernetes events
+// were received. It is used to implement the idle timeout. All functions
+// are safe to call on a nil tracker.
+type activityTracker struct {
+	last atomic.Int64
+}
+
+func newActivityTracker() *activityTracker {
+	t := &activityTracker{}
+	t.touch()
+
+	return t
+}
+
+func (t *activityTracker) touch() {
+	if t != nil {
+		t.last.Store(time.Now().UnixNano())
+	}
+}
+
+func (t *activityTracker) idleSince(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, t.last.Load()))
+}
+
+// monitor cancels the context with the given cause once no activity was
+// recorded for the given timeout. It returns when the context is done.
+func (t *activityTracker) monitor(ctx context.Context, cancel context.CancelCauseFunc, timeout time.Duration, cause error) {
+	interval := time.Second
+	if timeout < interval {
+		interval = timeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			if t.idleSince(now) >= timeout {
+				cancel(cause)
+				return
+			}
+		}
+	}
+}
+
+// wrap returns a reader that records activity whenever data is read.
+func (t *activityTracker) wrap(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+
+	return &activityReader{
+		r:        r,
+		activity: t,
+	}
+}
+
+type activityReader struct {
+	r        io.Reader
+	activity *activityTracker
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.activity.touch()
+	}
+
+	return n, err
+}
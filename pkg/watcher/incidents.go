@@ -0,0 +1,261 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.xrstf.de/protokol/pkg/collector"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// IncidentSource provides the events and the node of a pod at the time of
+// an incident.
+type IncidentSource interface {
+	PodEvents(ctx context.Context, pod *corev1.Pod) ([]corev1.Event, error)
+	Node(ctx context.Context, nodeName string) (*corev1.Node, error)
+}
+
+type clientsetIncidentSource struct {
+	clientset kubernetes.Interface
+}
+
+var _ IncidentSource = &clientsetIncidentSource{}
+
+// NewIncidentSource returns an IncidentSource that uses the given clientset
+// to request events and nodes from the Kubernetes apiserver.
+func NewIncidentSource(clientset kubernetes.Interface) IncidentSource {
+	return &clientsetIncidentSource{
+		clientset: clientset,
+	}
+}
+
+func (s *clientsetIncidentSource) PodEvents(ctx context.Context, pod *corev1.Pod) ([]corev1.Event, error) {
+	selector := fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": pod.Name,
+	}
+
+	if pod.UID != "" {
+		selector["involvedObject.uid"] = string(pod.UID)
+	}
+
+	events, err := s.clientset.CoreV1().Events(pod.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: selector.AsSelector().String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events.Items, nil
+}
+
+func (s *clientsetIncidentSource) Node(ctx context.Context, nodeName string) (*corev1.Node, error) {
+	return s.clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+}
+
+// pendingIncident is a restart or failure that has been detected, but whose
+// bundle has not been collected yet.
+type pendingIncident struct {
+	container    string
+	restartCount int32
+	// previous is true if the failed incarnation has already been replaced
+	// by a new one, so its logs must be requested as the previous logs.
+	previous    bool
+	termination *corev1.ContainerStateTerminated
+}
+
+type incidentState struct {
+	restartCount int32
+	// reported is the restart count of the last incarnation that an incident
+	// was recorded for, so that a failure followed by a restart results in
+	// only one incident.
+	reported int32
+}
+
+// incidentTracker detects restarts and failures of matched containers based
+// on the container statuses of each pod update.
+type incidentTracker struct {
+	containers map[string]*incidentState
+}
+
+func newIncidentTracker() *incidentTracker {
+	return &incidentTracker{
+		containers: map[string]*incidentState{},
+	}
+}
+
+// update returns all incidents since the last time the pod was seen. What
+// happened before a container was first seen is not considered an incident.
+func (t *incidentTracker) update(pod *corev1.Pod, statuses []corev1.ContainerStatus) []pendingIncident {
+	var incidents []pendingIncident
+
+	for _, status := range statuses {
+		key := fmt.Sprintf("%s/%s", podKey(pod), status.Name)
+
+		state, exists := t.containers[key]
+		if !exists {
+			state = &incidentState{
+				restartCount: status.RestartCount,
+				reported:     -1,
+			}
+
+			if status.State.Terminated != nil {
+				state.reported = status.RestartCount
+			}
+
+			t.containers[key] = state
+			continue
+		}
+
+		if status.RestartCount > state.restartCount {
+			previous := status.RestartCount - 1
+
+			if state.reported != previous {
+				incidents = append(incidents, pendingIncident{
+					container:    status.Name,
+					restartCount: previous,
+					previous:     true,
+					termination:  status.LastTerminationState.Terminated,
+				})
+				state.reported = previous
+			}
+
+			state.restartCount = status.RestartCount
+		}
+
+		if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode != 0 && state.reported != status.RestartCount {
+			incidents = append(incidents, pendingIncident{
+				container:    status.Name,
+				restartCount: status.RestartCount,
+				termination:  terminated,
+			})
+			state.reported = status.RestartCount
+		}
+	}
+
+	return incidents
+}
+
+// forget removes all containers of the given pod.
+func (t *incidentTracker) forget(pod *corev1.Pod) {
+	prefix := podKey(pod) + "/"
+
+	for key := range t.containers {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.containers, key)
+		}
+	}
+}
+
+// incidentTimeout is how long collecting a single incident may take.
+const incidentTimeout = 30 * time.Second
+
+func (w *Watcher) trackIncidents(ctx context.Context, wg *sync.WaitGroup, pod *corev1.Pod) {
+	if w.incidents == nil {
+		return
+	}
+
+	now := time.Now()
+
+	for _, incident := range w.incidents.update(pod, w.terminationCandidates(pod)) {
+		wg.Add(1)
+		go w.collectIncident(ctx, wg, pod, incident, now)
+	}
+}
+
+// collectIncident gathers the last log lines, the events and the node of
+// the incident and passes them to the collector.
+func (w *Watcher) collectIncident(ctx context.Context, wg *sync.WaitGroup, pod *corev1.Pod, pending pendingIncident, now time.Time) {
+	defer wg.Done()
+
+	log := w.getPodLog(pod).WithField("container", pending.container).WithField("restarts", pending.restartCount)
+	log.Warn("Container has restarted or failed, collecting incident…")
+
+	// the incident should be recorded even if protokol is stopping
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), incidentTimeout)
+	defer cancel()
+
+	incident := &collector.Incident{
+		Time:         now,
+		Pod:          pod,
+		Container:    pending.container,
+		RestartCount: pending.restartCount,
+	}
+
+	if pending.termination != nil {
+		incident.ExitCode = &pending.termination.ExitCode
+		incident.Reason = pending.termination.Reason
+	}
+
+	logOpts := &corev1.PodLogOptions{
+		Container: pending.container,
+		Previous:  pending.previous,
+	}
+
+	if w.opt.IncidentLines > 0 {
+		lines := int64(w.opt.IncidentLines)
+		logOpts.TailLines = &lines
+	}
+
+	stream, err := w.logStreamer.StreamLogs(ctx, pod.Namespace, pod.Name, logOpts)
+	if err != nil {
+		log.WithError(err).Warn("Failed to fetch logs for incident.")
+	} else {
+		logs, err := io.ReadAll(stream)
+		stream.Close()
+
+		if err != nil {
+			log.WithError(err).Warn("Failed to fetch logs for incident.")
+		}
+
+		incident.Logs = string(logs)
+	}
+
+	events, err := w.opt.Incidents.PodEvents(ctx, pod)
+	if err != nil {
+		log.WithError(err).Warn("Failed to fetch events for incident.")
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTimestamp(&events[i]).Before(eventTimestamp(&events[j]))
+	})
+
+	incident.Events = events
+
+	if pod.Spec.NodeName != "" {
+		node, err := w.opt.Incidents.Node(ctx, pod.Spec.NodeName)
+		if err != nil {
+			log.WithError(err).Warn("Failed to fetch node for incident.")
+		} else {
+			incident.Node = node
+		}
+	}
+
+	if err := w.collector.CollectIncident(ctx, incident); err != nil {
+		log.WithError(err).Error("Failed to collect incident.")
+	}
+}
+
+// eventTimestamp returns the most recent time at which the event was
+// observed.
+func eventTimestamp(event *corev1.Event) time.Time {
+	for _, t := range []time.Time{event.LastTimestamp.Time, event.EventTime.Time, event.FirstTimestamp.Time} {
+		if !t.IsZero() {
+			return t
+		}
+	}
+
+	return event.CreationTimestamp.Time
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// LogStreamer opens log streams for individual containers and nodes. It
+// exists so that the Watcher does not need a full Kubernetes clientset and
+// can be tested with fake streams.
+type LogStreamer interface {
+	StreamLogs(ctx context.Context, namespace string, podName string, opts *corev1.PodLogOptions) (io.ReadCloser, error)
+	// StreamNodeLogs returns the logs of a service (like "kubelet") or a
+	// file in /var/log on the given node. If since is not zero, only newer
+	// log lines are returned.
+	StreamNodeLogs(ctx context.Context, nodeName string, query string, since time.Time) (io.ReadCloser, error)
+}
+
+type clientsetLogStreamer struct {
+	clientset kubernetes.Interface
+}
+
+var _ LogStreamer = &clientsetLogStreamer{}
+
+// NewLogStreamer returns a LogStreamer that uses the given clientset to
+// request logs from the Kubernetes apiserver.
+func NewLogStreamer(clientset kubernetes.Interface) LogStreamer {
+	return &clientsetLogStreamer{
+		clientset: clientset,
+	}
+}
+
+func (s *clientsetLogStreamer) StreamLogs(ctx context.Context, namespace string, podName string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	return s.clientset.CoreV1().Pods(namespace).GetLogs(podName, opts).Stream(ctx)
+}
+
+// StreamNodeLogs uses the node log query feature of the kubelet, which is
+// reachable via the apiserver's node proxy. This requires the NodeLogQuery
+// feature gate and permissions for the nodes/proxy subresource.
+func (s *clientsetLogStreamer) StreamNodeLogs(ctx context.Context, nodeName string, query string, since time.Time) (io.ReadCloser, error) {
+	// the trailing slash is required by the kubelet and AbsPath only keeps
+	// it if the path is given as a single segment
+	request := s.clientset.CoreV1().RESTClient().Get().
+		AbsPath(fmt.Sprintf("/api/v1/nodes/%s/proxy/logs/", nodeName)).
+		Param("query", query)
+
+	if !since.IsZero() {
+		request = request.Param("sinceTime", since.UTC().Format(time.RFC3339))
+	}
+
+	return request.Stream(ctx)
+}
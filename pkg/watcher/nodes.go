@@ -0,0 +1,246 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"go.xrstf.de/protokol/pkg/collector"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+type nodeTimelineState struct {
+	conditions    map[corev1.NodeConditionType]corev1.ConditionStatus
+	taints        sets.Set[string]
+	unschedulable bool
+}
+
+// nodeTracker keeps track of the nodes that host matching pods and records
+// the changes of these nodes. It knows the latest state of all nodes, so
+// that the current state of a node can be recorded as soon as it becomes
+// relevant. nodeTracker is safe for concurrent use.
+type nodeTracker struct {
+	lock     sync.Mutex
+	relevant sets.Set[string]
+	latest   map[string]*corev1.Node
+	states   map[string]*nodeTimelineState
+	// since is the creation time of the oldest matching pod on each node.
+	since map[string]time.Time
+	now   func() time.Time
+}
+
+func newNodeTracker() *nodeTracker {
+	return &nodeTracker{
+		relevant: sets.New[string](),
+		latest:   map[string]*corev1.Node{},
+		states:   map[string]*nodeTimelineState{},
+		since:    map[string]time.Time{},
+		now:      time.Now,
+	}
+}
+
+func (t *nodeTracker) isRelevant(nodeName string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.relevant.Has(nodeName)
+}
+
+// relevantNodes returns the names of all relevant nodes, together with the
+// creation time of the oldest matching pod that was scheduled on them.
+func (t *nodeTracker) relevantNodes() map[string]time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	result := map[string]time.Time{}
+	for nodeName := range t.relevant {
+		result[nodeName] = t.since[nodeName]
+	}
+
+	return result
+}
+
+// addRelevant marks the node as relevant for a pod that was created at the
+// given time. If this node was not relevant before and its state is already
+// known, the node and its current state are returned.
+func (t *nodeTracker) addRelevant(nodeName string, podCreated time.Time) (*corev1.Node, []collector.TimelineEntry) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if nodeName == "" {
+		return nil, nil
+	}
+
+	if since, exists := t.since[nodeName]; !exists || podCreated.Before(since) {
+		t.since[nodeName] = podCreated
+	}
+
+	if t.relevant.Has(nodeName) {
+		return nil, nil
+	}
+
+	t.relevant.Insert(nodeName)
+
+	node, exists := t.latest[nodeName]
+	if !exists {
+		return nil, nil
+	}
+
+	return node, t.diff(node, false)
+}
+
+// update records the new state of a node and returns the changes since the
+// last update, if the node is relevant.
+func (t *nodeTracker) update(node *corev1.Node, deleted bool) []collector.TimelineEntry {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if deleted {
+		delete(t.latest, node.Name)
+	} else {
+		t.latest[node.Name] = node
+	}
+
+	if !t.relevant.Has(node.Name) {
+		return nil
+	}
+
+	return t.diff(node, deleted)
+}
+
+func (t *nodeTracker) diff(node *corev1.Node, deleted bool) []collector.TimelineEntry {
+	state, exists := t.states[node.Name]
+	if !exists {
+		state = &nodeTimelineState{
+			conditions: map[corev1.NodeConditionType]corev1.ConditionStatus{},
+			taints:     sets.New[string](),
+		}
+		t.states[node.Name] = state
+	}
+
+	var entries []collector.TimelineEntry
+
+	for _, condition := range node.Status.Conditions {
+		if state.conditions[condition.Type] == condition.Status {
+			continue
+		}
+
+		timestamp := condition.LastTransitionTime.Time
+		if timestamp.IsZero() {
+			timestamp = t.now()
+		}
+
+		entries = append(entries, collector.TimelineEntry{
+			Time:    timestamp,
+			Type:    "Condition",
+			Reason:  fmt.Sprintf("%s=%s", condition.Type, condition.Status),
+			Message: condition.Message,
+		})
+		state.conditions[condition.Type] = condition.Status
+	}
+
+	taints := sets.New[string]()
+	for _, taint := range node.Spec.Taints {
+		taints.Insert(taintString(taint))
+	}
+
+	for _, taint := range sets.List(taints.Difference(state.taints)) {
+		entries = append(entries, collector.TimelineEntry{
+			Time:   t.now(),
+			Type:   "TaintAdded",
+			Reason: taint,
+		})
+	}
+
+	for _, taint := range sets.List(state.taints.Difference(taints)) {
+		entries = append(entries, collector.TimelineEntry{
+			Time:   t.now(),
+			Type:   "TaintRemoved",
+			Reason: taint,
+		})
+	}
+
+	state.taints = taints
+
+	if node.Spec.Unschedulable != state.unschedulable {
+		entryType := "Cordoned"
+		if !node.Spec.Unschedulable {
+			entryType = "Uncordoned"
+		}
+
+		entries = append(entries, collector.TimelineEntry{
+			Time: t.now(),
+			Type: entryType,
+		})
+		state.unschedulable = node.Spec.Unschedulable
+	}
+
+	if deleted {
+		entries = append(entries, collector.TimelineEntry{
+			Time: t.now(),
+			Type: "Deleted",
+		})
+		delete(t.states, node.Name)
+	}
+
+	return entries
+}
+
+func taintString(taint corev1.Taint) string {
+	if taint.Value == "" {
+		return fmt.Sprintf("%s:%s", taint.Key, taint.Effect)
+	}
+
+	return fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect)
+}
+
+// nodeLogsTimeout is how long fetching all node logs may take in total.
+const nodeLogsTimeout = time.Minute
+
+// collectNodeLogs fetches the configured node logs of all relevant nodes.
+// Node logs cannot be followed, so this happens once when the Watcher stops,
+// in order to include everything that happened during the run.
+func (w *Watcher) collectNodeLogs(ctx context.Context) {
+	if w.nodes == nil || len(w.opt.NodeLogs) == 0 {
+		return
+	}
+
+	// the watch context is most likely done already
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), nodeLogsTimeout)
+	defer cancel()
+
+	for nodeName, since := range w.nodes.relevantNodes() {
+		for _, query := range w.opt.NodeLogs {
+			log := w.log.WithField("node", nodeName).WithField("log", query)
+
+			if err := w.collectNodeLog(ctx, log, nodeName, query, since); err != nil {
+				if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) {
+					log.WithError(err).Warn("Node logs are not available, this requires the NodeLogQuery feature and access to nodes/proxy.")
+				} else {
+					log.WithError(err).Error("Failed to collect node logs.")
+				}
+			}
+		}
+	}
+}
+
+func (w *Watcher) collectNodeLog(ctx context.Context, log logrus.FieldLogger, nodeName string, query string, since time.Time) error {
+	log.Info("Collecting node logs…")
+
+	stream, err := w.logStreamer.StreamNodeLogs(ctx, nodeName, query, since)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return w.collector.CollectNodeLogs(ctx, log, nodeName, query, stream)
+}
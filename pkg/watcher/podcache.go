@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// eventBufferTimeout is how long events for unknown pods are held back,
+	// waiting for the pod to show up in the pod watch.
+	eventBufferTimeout = 5 * time.Second
+
+	// deletedPodRetention is how long the labels of deleted pods are kept,
+	// as events for a pod can still arrive after it was deleted.
+	deletedPodRetention = time.Minute
+)
+
+type cachedPod struct {
+	labels    labels.Set
+	deletedAt time.Time
+}
+
+type pendingEvent struct {
+	event    *corev1.Event
+	received time.Time
+}
+
+// podCache remembers the labels of all pods seen by the Watcher, so that
+// the label selector can be applied to events, which only reference the
+// pod they are about. Events for pods that were not seen yet are buffered
+// for a short time. podCache is safe for concurrent use.
+type podCache struct {
+	lock    sync.Mutex
+	pods    map[types.UID]cachedPod
+	pending map[types.UID][]pendingEvent
+}
+
+func newPodCache() *podCache {
+	return &podCache{
+		pods:    map[types.UID]cachedPod{},
+		pending: map[types.UID][]pendingEvent{},
+	}
+}
+
+// observe records the pod and returns all events that were buffered for it.
+func (c *podCache) observe(pod *corev1.Pod, deleted bool) []*corev1.Event {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry := cachedPod{
+		labels: labels.Set(pod.Labels),
+	}
+
+	if deleted {
+		entry.deletedAt = time.Now()
+	}
+
+	c.pods[pod.UID] = entry
+
+	pending := c.pending[pod.UID]
+	delete(c.pending, pod.UID)
+
+	events := make([]*corev1.Event, 0, len(pending))
+	for _, p := range pending {
+		events = append(events, p.event)
+	}
+
+	return events
+}
+
+// labels returns the labels of the given pod, if it is known.
+func (c *podCache) labels(uid types.UID) (labels.Set, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, exists := c.pods[uid]
+
+	return entry.labels, exists
+}
+
+// buffer holds back an event until its pod is observed or it expires.
+func (c *podCache) buffer(event *corev1.Event) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	uid := event.InvolvedObject.UID
+	c.pending[uid] = append(c.pending[uid], pendingEvent{
+		event:    event,
+		received: time.Now(),
+	})
+}
+
+// expire returns all buffered events that are older than the buffer
+// timeout (or all events, if force is true) and forgets deleted pods
+// once their retention period is over.
+func (c *podCache) expire(now time.Time, force bool) []*corev1.Event {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var expired []*corev1.Event
+
+	for uid, events := range c.pending {
+		var remaining []pendingEvent
+
+		for _, p := range events {
+			if force || now.Sub(p.received) >= eventBufferTimeout {
+				expired = append(expired, p.event)
+			} else {
+				remaining = append(remaining, p)
+			}
+		}
+
+		if len(remaining) > 0 {
+			c.pending[uid] = remaining
+		} else {
+			delete(c.pending, uid)
+		}
+	}
+
+	for uid, pod := range c.pods {
+		if !pod.deletedAt.IsZero() && now.Sub(pod.deletedAt) >= deletedPodRetention {
+			delete(c.pods, uid)
+		}
+	}
+
+	return expired
+}
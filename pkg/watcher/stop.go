@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// StopConditions define when the Watcher should stop watching for new pods
+// on its own. If multiple conditions are configured, the first one that is
+// met stops the Watcher.
+type StopConditions struct {
+	// AllTerminated stops the Watcher once all matching pods have
+	// terminated (i.e. succeeded, failed or were deleted).
+	AllTerminated bool
+	// Completion stops the Watcher once a matching pod that also matches
+	// this selector has succeeded or failed.
+	Completion labels.Selector
+	// Idle stops the Watcher once there were no active matching pods for
+	// the given duration.
+	Idle time.Duration
+}
+
+func (c StopConditions) enabled() bool {
+	return c.AllTerminated || c.Completion != nil || c.Idle > 0
+}
+
+// Result describes the outcome of Watcher.Watch.
+type Result struct {
+	// StopReason is a human readable description of the stop condition
+	// that was met. It is empty if no stop condition was met and Watch
+	// returned because the watches ended.
+	StopReason string
+	// PodsFailed is true if a stop condition was met and the pods relevant
+	// for it failed.
+	PodsFailed bool
+	// Failures contains all crashed containers, if failure detection was
+	// enabled.
+	Failures []ContainerFailure
+	// DroppedLines is the number of log lines that were dropped because
+	// the collectors could not keep up with the log streams.
+	DroppedLines int64
+}
+
+// stopTracker keeps track of the phases of all matching pods and evaluates
+// the configured StopConditions.
+type stopTracker struct {
+	cond       StopConditions
+	phases     map[string]corev1.PodPhase
+	seenAny    bool
+	anyFailed  bool
+	lastActive time.Time
+}
+
+func newStopTracker(cond StopConditions) *stopTracker {
+	return &stopTracker{
+		cond:       cond,
+		phases:     map[string]corev1.PodPhase{},
+		lastActive: time.Now(),
+	}
+}
+
+func podTerminated(phase corev1.PodPhase) bool {
+	return phase == corev1.PodSucceeded || phase == corev1.PodFailed
+}
+
+// update records the new state of a matching pod and returns a non-nil
+// result if this update has met a stop condition.
+func (t *stopTracker) update(pod *corev1.Pod, deleted bool) *Result {
+	if result := t.record(pod, deleted); result != nil {
+		return result
+	}
+
+	return t.evaluate()
+}
+
+// record records the new state of a matching pod, but only evaluates the
+// completion condition, which concerns this single pod. This allows to
+// record a number of pods before evaluating the other conditions.
+func (t *stopTracker) record(pod *corev1.Pod, deleted bool) *Result {
+	key := podKey(pod)
+	phase := pod.Status.Phase
+
+	t.seenAny = true
+
+	if phase == corev1.PodFailed {
+		t.anyFailed = true
+	}
+
+	if deleted {
+		delete(t.phases, key)
+	} else {
+		t.phases[key] = phase
+	}
+
+	if t.cond.Completion != nil && podTerminated(phase) && t.cond.Completion.Matches(labels.Set(pod.Labels)) {
+		return &Result{
+			StopReason: fmt.Sprintf("pod %s/%s has %s", pod.Namespace, pod.Name, phase),
+			PodsFailed: phase == corev1.PodFailed,
+		}
+	}
+
+	return nil
+}
+
+// evaluate checks the stop conditions that concern all recorded pods.
+func (t *stopTracker) evaluate() *Result {
+	if t.cond.AllTerminated && t.allTerminated() {
+		return &Result{
+			StopReason: "all pods have terminated",
+			PodsFailed: t.anyFailed,
+		}
+	}
+
+	if t.hasActivePods() {
+		t.lastActive = time.Now()
+	}
+
+	return nil
+}
+
+// check evaluates the time-based stop conditions.
+func (t *stopTracker) check(now time.Time) *Result {
+	if t.cond.Idle <= 0 {
+		return nil
+	}
+
+	if t.hasActivePods() {
+		t.lastActive = now
+		return nil
+	}
+
+	if now.Sub(t.lastActive) >= t.cond.Idle {
+		return &Result{
+			StopReason: fmt.Sprintf("no active pods for %v", t.cond.Idle),
+			PodsFailed: t.anyFailed,
+		}
+	}
+
+	return nil
+}
+
+func (t *stopTracker) allTerminated() bool {
+	return t.seenAny && !t.hasActivePods()
+}
+
+func (t *stopTracker) hasActivePods() bool {
+	for _, phase := range t.phases {
+		if !podTerminated(phase) {
+			return true
+		}
+	}
+
+	return false
+}
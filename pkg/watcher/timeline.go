@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"fmt"
+	"time"
+
+	"go.xrstf.de/protokol/pkg/collector"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type containerTimelineState struct {
+	state        string
+	reason       string
+	restartCount int32
+	startedAt    time.Time
+	finishedAt   time.Time
+}
+
+type podTimelineState struct {
+	phase      corev1.PodPhase
+	conditions map[corev1.PodConditionType]corev1.ConditionStatus
+	containers map[string]containerTimelineState
+	deleting   bool
+}
+
+// timelineTracker remembers the last observed state of each pod, so that
+// the lifecycle transitions between two pod updates can be determined.
+type timelineTracker struct {
+	pods map[string]*podTimelineState
+	now  func() time.Time
+}
+
+func newTimelineTracker() *timelineTracker {
+	return &timelineTracker{
+		pods: map[string]*podTimelineState{},
+		now:  time.Now,
+	}
+}
+
+// update returns all transitions since the last time the pod was seen. If
+// the pod is seen for the first time, its entire current state is returned.
+func (t *timelineTracker) update(pod *corev1.Pod, deleted bool) []collector.TimelineEntry {
+	key := podKey(pod)
+
+	state, exists := t.pods[key]
+	if !exists {
+		state = &podTimelineState{
+			conditions: map[corev1.PodConditionType]corev1.ConditionStatus{},
+			containers: map[string]containerTimelineState{},
+		}
+		t.pods[key] = state
+	}
+
+	var entries []collector.TimelineEntry
+
+	if pod.Status.Phase != "" && pod.Status.Phase != state.phase {
+		entries = append(entries, collector.TimelineEntry{
+			Time:   t.now(),
+			Type:   "Phase",
+			Reason: string(pod.Status.Phase),
+		})
+		state.phase = pod.Status.Phase
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if state.conditions[condition.Type] == condition.Status {
+			continue
+		}
+
+		entries = append(entries, collector.TimelineEntry{
+			Time:    t.timeOrNow(condition.LastTransitionTime),
+			Type:    "Condition",
+			Reason:  fmt.Sprintf("%s=%s", condition.Type, condition.Status),
+			Message: condition.Message,
+		})
+		state.conditions[condition.Type] = condition.Status
+	}
+
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			entries = append(entries, t.containerTransitions(state, status)...)
+		}
+	}
+
+	if pod.DeletionTimestamp != nil && !state.deleting {
+		entries = append(entries, collector.TimelineEntry{
+			Time: pod.DeletionTimestamp.Time,
+			Type: "DeletionRequested",
+		})
+		state.deleting = true
+	}
+
+	if deleted {
+		entries = append(entries, collector.TimelineEntry{
+			Time: t.now(),
+			Type: "Deleted",
+		})
+		delete(t.pods, key)
+	}
+
+	return entries
+}
+
+func (t *timelineTracker) containerTransitions(pod *podTimelineState, status corev1.ContainerStatus) []collector.TimelineEntry {
+	var entries []collector.TimelineEntry
+
+	previous, exists := pod.containers[status.Name]
+	current := containerTimelineState{
+		restartCount: status.RestartCount,
+		// keep the last termination time, in case the container is already running again
+		finishedAt: previous.finishedAt,
+	}
+
+	// restarts can happen quicker than we see the pod updates, so the termination
+	// of the previous incarnation might only be visible in the last termination state
+	if last := status.LastTerminationState.Terminated; last != nil && last.FinishedAt.Time.After(current.finishedAt) {
+		if exists {
+			entries = append(entries, terminatedEntry(status.Name, last))
+		}
+		current.finishedAt = last.FinishedAt.Time
+	}
+
+	if exists && status.RestartCount > previous.restartCount {
+		entries = append(entries, collector.TimelineEntry{
+			Time:      t.now(),
+			Type:      "ContainerRestarted",
+			Container: status.Name,
+			Message:   fmt.Sprintf("restart count is now %d", status.RestartCount),
+		})
+	}
+
+	switch {
+	case status.State.Waiting != nil:
+		current.state = "waiting"
+		current.reason = status.State.Waiting.Reason
+
+		if previous.state != current.state || previous.reason != current.reason {
+			entries = append(entries, collector.TimelineEntry{
+				Time:      t.now(),
+				Type:      "ContainerWaiting",
+				Container: status.Name,
+				Reason:    status.State.Waiting.Reason,
+				Message:   status.State.Waiting.Message,
+			})
+		}
+
+	case status.State.Running != nil:
+		current.state = "running"
+		current.startedAt = status.State.Running.StartedAt.Time
+
+		if previous.state != current.state || !previous.startedAt.Equal(current.startedAt) {
+			entries = append(entries, collector.TimelineEntry{
+				Time:      t.timeOrNow(status.State.Running.StartedAt),
+				Type:      "ContainerRunning",
+				Container: status.Name,
+			})
+		}
+
+	case status.State.Terminated != nil:
+		terminated := status.State.Terminated
+		current.state = "terminated"
+
+		if !terminated.FinishedAt.Time.Equal(current.finishedAt) || previous.state != current.state {
+			entries = append(entries, terminatedEntry(status.Name, terminated))
+		}
+		current.finishedAt = terminated.FinishedAt.Time
+	}
+
+	pod.containers[status.Name] = current
+
+	return entries
+}
+
+func terminatedEntry(containerName string, state *corev1.ContainerStateTerminated) collector.TimelineEntry {
+	exitCode := state.ExitCode
+
+	return collector.TimelineEntry{
+		Time:      state.FinishedAt.Time,
+		Type:      "ContainerTerminated",
+		Container: containerName,
+		Reason:    state.Reason,
+		Message:   state.Message,
+		ExitCode:  &exitCode,
+	}
+}
+
+func (t *timelineTracker) timeOrNow(ts metav1.Time) time.Time {
+	if ts.IsZero() {
+		return t.now()
+	}
+
+	return ts.Time
+}
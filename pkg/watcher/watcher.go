@@ -9,28 +9,50 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"go.xrstf.de/protokol/pkg/collector"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/watch"
-	"k8s.io/client-go/kubernetes"
 )
 
 type Watcher struct {
-	clientset      *kubernetes.Clientset
+	logStreamer    LogStreamer
 	log            logrus.FieldLogger
 	collector      collector.Collector
 	initialPods    []corev1.Pod
 	initialEvents  []corev1.Event
+	initialNodes   []corev1.Node
 	opt            Options
-	seenContainers sets.Set[string]
+	seenContainers map[string]sets.Set[string]
+	podCollectors  map[string]podCollectors
+	activity       *activityTracker
+	failures       *failureTracker
+	timeline       *timelineTracker
+	pods           *podCache
+	nodes          *nodeTracker
+	incidents      *incidentTracker
+	// bufferEvents is true if events for unknown pods should be held back
+	// until the pod shows up in the pod watch.
+	bufferEvents bool
+	// droppedLines counts the log lines that were dropped because the
+	// collectors could not keep up.
+	droppedLines atomic.Int64
+}
+
+// podCollectors allows to cancel all log collectors of a single pod.
+type podCollectors struct {
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type Options struct {
@@ -42,40 +64,160 @@ type Options struct {
 	OneShot        bool
 	DumpMetadata   bool
 	DumpEvents     bool
+	// EventKinds are patterns for the kinds of objects whose events should
+	// be dumped in addition to Pod events. Events for non-Pod objects are
+	// only filtered by namespace.
+	EventKinds     []string
+	StopConditions StopConditions
+	// IdleTimeout makes Watch return once no log data and no Kubernetes
+	// events were received for the given duration.
+	IdleTimeout time.Duration
+	// DetectFailures enables tracking crashed containers, which are then
+	// reported in the Result.
+	DetectFailures bool
+	// MaxRestarts is the number of restarts during the run that a container
+	// may have before it is considered failed (if DetectFailures is enabled).
+	MaxRestarts int
+	// DumpTimeline enables recording the lifecycle transitions of each pod.
+	DumpTimeline bool
+	// WatchNodes enables recording condition changes, taints and events
+	// of the nodes that host matching pods.
+	WatchNodes bool
+	// NodeLogs are the node logs (service names like "kubelet" or files in
+	// /var/log) to fetch from the nodes that host matching pods.
+	NodeLogs []string
+	// Incidents enables collecting a bundle with the last log lines, the
+	// pod, its events and its node whenever a matching container restarts
+	// or exits with an error.
+	Incidents IncidentSource
+	// IncidentLines is the number of log lines to include in each incident.
+	// If 0, all log lines of the failed incarnation are included.
+	IncidentLines int
+	// Buffer configures the buffering between log streams and collectors.
+	Buffer BufferOptions
 }
 
 func NewWatcher(
-	clientset *kubernetes.Clientset,
+	logStreamer LogStreamer,
 	c collector.Collector,
 	log logrus.FieldLogger,
 	initialPods []corev1.Pod,
 	initialEvents []corev1.Event,
+	initialNodes []corev1.Node,
 	opt Options,
 ) *Watcher {
-	return &Watcher{
-		clientset:      clientset,
+	w := &Watcher{
+		logStreamer:    logStreamer,
 		log:            log,
 		collector:      c,
 		initialPods:    initialPods,
 		initialEvents:  initialEvents,
+		initialNodes:   initialNodes,
 		opt:            opt,
-		seenContainers: sets.New[string](),
+		seenContainers: map[string]sets.Set[string]{},
+		podCollectors:  map[string]podCollectors{},
+		timeline:       newTimelineTracker(),
+	}
+
+	// the label selector can only be applied to events if the labels of all pods are known
+	if opt.LabelSelector != nil && opt.DumpEvents {
+		w.pods = newPodCache()
+	}
+
+	if opt.WatchNodes || len(opt.NodeLogs) > 0 {
+		w.nodes = newNodeTracker()
+	}
+
+	if opt.Incidents != nil {
+		w.incidents = newIncidentTracker()
+	}
+
+	return w
+}
+
+// Watch processes the initial pods and events and then watches for changes
+// until either the watches end, a stop condition is met or the context is
+// done. Once the context is done, all log streams are cancelled.
+func (w *Watcher) Watch(ctx context.Context, podWatcher watch.Interface, eventWatcher watch.Interface, nodeWatcher watch.Interface) Result {
+	if w.opt.DetectFailures {
+		w.failures = newFailureTracker(w.opt.MaxRestarts, time.Now())
+	}
+
+	result := w.watch(ctx, podWatcher, eventWatcher, nodeWatcher)
+
+	w.collectNodeLogs(ctx)
+
+	if w.failures != nil {
+		result.Failures = w.failures.failures()
 	}
+
+	result.DroppedLines = w.droppedLines.Load()
+
+	return result
 }
 
-func (w *Watcher) Watch(ctx context.Context, podWatcher watch.Interface, eventWatcher watch.Interface) {
+// drainTimeout is how long Watch waits for log streams to end on their own
+// after a stop condition was met, before cancelling them.
+const drainTimeout = 10 * time.Second
+
+func (w *Watcher) watch(ctx context.Context, podWatcher watch.Interface, eventWatcher watch.Interface, nodeWatcher watch.Interface) Result {
 	wg := sync.WaitGroup{}
 
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	if w.opt.IdleTimeout > 0 {
+		w.activity = newActivityTracker()
+		go w.activity.monitor(ctx, cancel, w.opt.IdleTimeout, fmt.Errorf("no activity for %v", w.opt.IdleTimeout))
+	}
+
+	// log streams get their own context, so they can be cancelled after
+	// a stop condition was met
+	collectCtx, cancelCollectors := context.WithCancel(ctx)
+	defer cancelCollectors()
+
+	var (
+		tracker *stopTracker
+		result  *Result
+	)
+
+	if w.opt.StopConditions.enabled() {
+		tracker = newStopTracker(w.opt.StopConditions)
+	}
+
+	// without a pod watch, all pods are known from the start
+	w.bufferEvents = podWatcher != nil
+
+	// nodes only become relevant once a matching pod is scheduled on them,
+	// until then their latest state is just remembered
+	for i := range w.initialNodes {
+		w.updateNode(ctx, &w.initialNodes[i], false)
+	}
+
 	for i := range w.initialPods {
+		w.observePod(ctx, &w.initialPods[i], false)
+
 		if w.podMatchesCriteria(&w.initialPods[i]) {
-			w.startLogCollectors(ctx, &wg, &w.initialPods[i])
+			w.startLogCollectors(collectCtx, &wg, &w.initialPods[i])
+			w.trackFailures(&w.initialPods[i])
+			w.trackIncidents(ctx, &wg, &w.initialPods[i])
+			w.trackNode(ctx, &w.initialPods[i])
+			w.dumpTimeline(ctx, &w.initialPods[i], false)
+
+			// the other conditions can only be evaluated once all
+			// initial pods are known
+			if tracker != nil && result == nil {
+				result = tracker.record(&w.initialPods[i], false)
+			}
 		}
 	}
 
+	if tracker != nil && result == nil {
+		result = tracker.evaluate()
+	}
+
 	for i := range w.initialEvents {
-		if w.eventMatchesCriteria(&w.initialEvents[i]) {
-			w.dumpEvent(ctx, &w.initialEvents[i])
-		}
+		w.processEvent(ctx, &w.initialEvents[i], true)
 	}
 
 	// eventWatcher is nil if neither --events not --raw-events was not specified.
@@ -83,49 +225,228 @@ func (w *Watcher) Watch(ctx context.Context, podWatcher watch.Interface, eventWa
 		wg.Add(1)
 
 		go func() {
-			for event := range eventWatcher.ResultChan() {
-				unstructuredObj, ok := event.Object.(*unstructured.Unstructured)
+			defer wg.Done()
+
+			var ticks <-chan time.Time
+			if w.pods != nil {
+				ticker := time.NewTicker(time.Second)
+				defer ticker.Stop()
+
+				ticks = ticker.C
+			}
+
+			for {
+				select {
+				case event, ok := <-eventWatcher.ResultChan():
+					if !ok {
+						// do not lose events that are still waiting for their pods
+						w.processExpiredEvents(ctx, time.Now(), true)
+						return
+					}
+
+					w.activity.touch()
+
+					unstructuredObj, ok := event.Object.(*unstructured.Unstructured)
+					if !ok {
+						continue
+					}
+
+					k8sEvent, err := eventFromUnstructured(unstructuredObj)
+					if err != nil {
+						continue
+					}
+
+					w.processEvent(ctx, k8sEvent, true)
+
+				case now := <-ticks:
+					w.processExpiredEvents(ctx, now, false)
+				}
+			}
+		}()
+	}
+
+	// nodeWatcher is nil unless --nodes was specified
+	if nodeWatcher != nil && w.nodes != nil {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for event := range nodeWatcher.ResultChan() {
+				obj, ok := event.Object.(*unstructured.Unstructured)
 				if !ok {
 					continue
 				}
 
-				k8sEvent := &corev1.Event{}
-				err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredObj.UnstructuredContent(), k8sEvent)
+				node := &corev1.Node{}
+				err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), node)
 				if err != nil {
 					continue
 				}
 
-				if w.eventMatchesCriteria(k8sEvent) {
-					w.dumpEvent(ctx, k8sEvent)
-				}
+				w.updateNode(ctx, node, event.Type == watch.Deleted)
 			}
-
-			wg.Done()
 		}()
 	}
 
 	// wi can be nil if we do not want to actually watch, but instead
 	// just process the initial pods (if --oneshot is given)
-	if podWatcher != nil {
-		for event := range podWatcher.ResultChan() {
-			obj, ok := event.Object.(*unstructured.Unstructured)
-			if !ok {
-				continue
-			}
+	if podWatcher != nil && result == nil {
+		var ticks <-chan time.Time
+		if tracker != nil && w.opt.StopConditions.Idle > 0 {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
 
-			pod := &corev1.Pod{}
-			err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), pod)
-			if err != nil {
-				continue
+			ticks = ticker.C
+		}
+
+	loop:
+		for {
+			select {
+			case event, ok := <-podWatcher.ResultChan():
+				if !ok {
+					break loop
+				}
+
+				w.activity.touch()
+
+				obj, ok := event.Object.(*unstructured.Unstructured)
+				if !ok {
+					continue
+				}
+
+				pod := &corev1.Pod{}
+				err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), pod)
+				if err != nil {
+					continue
+				}
+
+				w.observePod(ctx, pod, event.Type == watch.Deleted)
+
+				if w.podMatchesCriteria(pod) {
+					if event.Type == watch.Deleted {
+						w.handlePodDeletion(ctx, pod)
+					} else {
+						w.startLogCollectors(collectCtx, &wg, pod)
+						w.trackFailures(pod)
+						w.trackIncidents(ctx, &wg, pod)
+						w.trackNode(ctx, pod)
+						w.dumpTimeline(ctx, pod, false)
+					}
+
+					if tracker != nil {
+						result = tracker.update(pod, event.Type == watch.Deleted)
+					}
+				}
+
+			case now := <-ticks:
+				result = tracker.check(now)
+
+			case <-ctx.Done():
+				result = &Result{StopReason: context.Cause(ctx).Error()}
 			}
 
-			if w.podMatchesCriteria(pod) {
-				w.startLogCollectors(ctx, &wg, pod)
+			if result != nil {
+				break loop
 			}
 		}
 	}
 
-	wg.Wait()
+	if result == nil {
+		wg.Wait()
+
+		// in oneshot mode, the context can still end before all logs were downloaded
+		if ctx.Err() != nil {
+			return Result{StopReason: context.Cause(ctx).Error()}
+		}
+
+		return Result{}
+	}
+
+	if ctx.Err() != nil {
+		w.log.WithField("reason", result.StopReason).Info("Stopping, cancelling remaining streams…")
+	} else {
+		w.log.WithField("reason", result.StopReason).Info("Stop condition met, waiting for logs to finish…")
+	}
+
+	if podWatcher != nil {
+		podWatcher.Stop()
+	}
+
+	if eventWatcher != nil {
+		eventWatcher.Stop()
+	}
+
+	if nodeWatcher != nil {
+		nodeWatcher.Stop()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		<-done
+	case <-time.After(drainTimeout):
+		w.log.Warn("Logs did not finish in time, cancelling remaining streams.")
+		cancelCollectors()
+		<-done
+	}
+
+	return *result
+}
+
+// podDeletionGracePeriod is how long the log collectors of a deleted pod
+// are given to finish on their own before they are cancelled.
+const podDeletionGracePeriod = 5 * time.Second
+
+// handlePodDeletion records the final state of a deleted pod, closes out
+// its log collectors and forgets everything the Watcher knew about it.
+func (w *Watcher) handlePodDeletion(ctx context.Context, pod *corev1.Pod) {
+	key := podKey(pod)
+
+	w.getPodLog(pod).Debug("Pod has been deleted.")
+
+	// Collectors rely on the deletion timestamp to recognize the final state
+	// of a pod, but it is not set when a pod is force-deleted.
+	if pod.DeletionTimestamp == nil {
+		now := metav1.Now()
+		pod.DeletionTimestamp = &now
+	}
+
+	w.dumpPodMetadata(ctx, pod)
+	w.dumpTimeline(ctx, pod, true)
+
+	if w.failures != nil {
+		w.failures.forget(pod)
+	}
+
+	if w.incidents != nil {
+		w.incidents.forget(pod)
+	}
+
+	// the containers are gone, so the streams should end soon on their own;
+	// give them a bit of time to deliver their last lines before cancelling
+	if collectors, exists := w.podCollectors[key]; exists {
+		time.AfterFunc(podDeletionGracePeriod, collectors.cancel)
+		delete(w.podCollectors, key)
+	}
+
+	delete(w.seenContainers, key)
+}
+
+// podKey identifies a pod by its UID, so that pods which reuse the name of
+// an earlier pod (e.g. StatefulSet pods) are not confused with each other.
+func podKey(pod *corev1.Pod) string {
+	if pod.UID != "" {
+		return string(pod.UID)
+	}
+
+	return fmt.Sprintf("%s/%s", pod.Namespace, pod.Name)
 }
 
 func (w *Watcher) startLogCollectors(ctx context.Context, wg *sync.WaitGroup, pod *corev1.Pod) {
@@ -134,6 +455,81 @@ func (w *Watcher) startLogCollectors(ctx context.Context, wg *sync.WaitGroup, po
 	w.startLogCollectorsForContainers(ctx, wg, pod, pod.Spec.Containers, pod.Status.ContainerStatuses)
 }
 
+func (w *Watcher) trackFailures(pod *corev1.Pod) {
+	if w.failures == nil {
+		return
+	}
+
+	w.failures.update(pod, w.terminationCandidates(pod))
+}
+
+// terminationCandidates returns the statuses of all matching containers
+// whose terminations are relevant for failures and incidents. Containers of
+// terminating pods are killed and usually exit with an error, which is
+// neither a crash nor an incident, so none are returned for them.
+func (w *Watcher) terminationCandidates(pod *corev1.Pod) []corev1.ContainerStatus {
+	if pod.DeletionTimestamp != nil {
+		return nil
+	}
+
+	var statuses []corev1.ContainerStatus
+	for _, list := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range list {
+			if w.containerNameMatches(status.Name) {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+
+	return statuses
+}
+
+// trackNode marks the node of a matching pod as relevant and records its
+// current state, if it was not relevant before.
+func (w *Watcher) trackNode(ctx context.Context, pod *corev1.Pod) {
+	if w.nodes == nil {
+		return
+	}
+
+	node, entries := w.nodes.addRelevant(pod.Spec.NodeName, pod.CreationTimestamp.Time)
+	if node != nil {
+		w.dumpNodeTimeline(ctx, node, entries)
+	}
+}
+
+func (w *Watcher) updateNode(ctx context.Context, node *corev1.Node, deleted bool) {
+	if w.nodes == nil {
+		return
+	}
+
+	w.dumpNodeTimeline(ctx, node, w.nodes.update(node, deleted))
+}
+
+func (w *Watcher) dumpNodeTimeline(ctx context.Context, node *corev1.Node, entries []collector.TimelineEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	if err := w.collector.CollectNodeTimeline(ctx, node, entries); err != nil {
+		w.log.WithField("node", node.Name).WithError(err).Error("Failed to collect node timeline.")
+	}
+}
+
+func (w *Watcher) dumpTimeline(ctx context.Context, pod *corev1.Pod, deleted bool) {
+	if !w.opt.DumpTimeline {
+		return
+	}
+
+	entries := w.timeline.update(pod, deleted)
+	if len(entries) == 0 {
+		return
+	}
+
+	if err := w.collector.CollectTimeline(ctx, pod, entries); err != nil {
+		w.getPodLog(pod).WithError(err).Error("Failed to collect pod timeline.")
+	}
+}
+
 func (w *Watcher) dumpEvent(ctx context.Context, event *corev1.Event) {
 	if !w.opt.DumpEvents {
 		return
@@ -156,6 +552,7 @@ func (w *Watcher) dumpPodMetadata(ctx context.Context, pod *corev1.Pod) {
 
 func (w *Watcher) startLogCollectorsForContainers(ctx context.Context, wg *sync.WaitGroup, pod *corev1.Pod, containers []corev1.Container, statuses []corev1.ContainerStatus) {
 	podLog := w.getPodLog(pod)
+	key := podKey(pod)
 
 	for _, container := range containers {
 		containerName := container.Name
@@ -191,20 +588,30 @@ func (w *Watcher) startLogCollectorsForContainers(ctx context.Context, wg *sync.
 			continue
 		}
 
-		ident := fmt.Sprintf("%s:%s:%s:%d", pod.Namespace, pod.Name, containerName, status.RestartCount)
+		ident := fmt.Sprintf("%s:%d", containerName, status.RestartCount)
 
 		// we have already started a collector for this incarnation of the container;
 		// whenever a container restarts, we want to create a new collector with the
 		// new restart count
-		if w.seenContainers.Has(ident) {
+		if w.seenContainers[key].Has(ident) {
 			continue
 		}
 
 		// remember that we have seen this incarnation
-		w.seenContainers.Insert(ident)
+		if _, exists := w.seenContainers[key]; !exists {
+			w.seenContainers[key] = sets.New[string]()
+		}
+		w.seenContainers[key].Insert(ident)
+
+		// all collectors of a pod share a context, so they can be cancelled when the pod is deleted
+		collectors, exists := w.podCollectors[key]
+		if !exists {
+			collectors.ctx, collectors.cancel = context.WithCancel(ctx)
+			w.podCollectors[key] = collectors
+		}
 
 		wg.Add(1)
-		go w.collectLogs(ctx, wg, containerLog, pod, containerName, int(status.RestartCount))
+		go w.collectLogs(collectors.ctx, wg, containerLog, pod, containerName, int(status.RestartCount))
 	}
 }
 
@@ -213,25 +620,63 @@ func (w *Watcher) collectLogs(ctx context.Context, wg *sync.WaitGroup, log logru
 
 	log.Info("Starting to collect logs…")
 
-	request := w.clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+	stream, err := w.logStreamer.StreamLogs(ctx, pod.Namespace, pod.Name, &corev1.PodLogOptions{
 		Container: containerName,
 		Follow:    !w.opt.OneShot,
 	})
-
-	stream, err := request.Stream(ctx)
 	if err != nil {
 		log.WithError(err).Error("Failed to stream logs.")
 		return
 	}
 	defer stream.Close()
 
-	if err := w.collector.CollectLogs(ctx, log, pod, containerName, stream); err != nil {
+	reader := w.activity.wrap(stream)
+
+	var buffer *lineBuffer
+	if w.opt.Buffer.Lines > 0 {
+		buffer = newLineBuffer(reader, w.opt.Buffer)
+		reader = buffer
+	}
+
+	err = w.collector.CollectLogs(ctx, log, pod, containerName, reader)
+
+	if buffer != nil {
+		// the buffer only stops reading once the stream is closed
+		buffer.stop()
+		stream.Close()
+
+		w.finishBuffer(log, buffer)
+	}
+
+	if err != nil {
+		// errors are expected when the stream is cancelled during shutdown
+		if ctx.Err() != nil {
+			log.Info("Log collection has been cancelled.")
+			return
+		}
+
 		log.WithError(err).Error("Failed to collect logs.")
 	}
 
 	log.Info("Logs have finished.")
 }
 
+func (w *Watcher) finishBuffer(log logrus.FieldLogger, buffer *lineBuffer) {
+	dropped, spilled, err := buffer.wait()
+	if err != nil {
+		log.WithError(err).Error("Failed to spill log lines to disk.")
+	}
+
+	if spilled > 0 {
+		log.WithField("lines", spilled).Debug("Log lines were temporarily spilled to disk because the collectors could not keep up.")
+	}
+
+	if dropped > 0 {
+		log.WithField("lines", dropped).Warn("Dropped log lines because the collectors could not keep up.")
+		w.droppedLines.Add(int64(dropped))
+	}
+}
+
 func (w *Watcher) getPodLog(pod *corev1.Pod) logrus.FieldLogger {
 	return w.log.WithField("pod", pod.Name).WithField("namespace", pod.Namespace)
 }
@@ -243,15 +688,68 @@ func (w *Watcher) podMatchesCriteria(pod *corev1.Pod) bool {
 }
 
 func (w *Watcher) getEventLog(obj corev1.ObjectReference) logrus.FieldLogger {
+	if obj.Kind != "Pod" {
+		return w.log.WithField("kind", obj.Kind).WithField("name", obj.Name).WithField("namespace", obj.Namespace)
+	}
+
 	return w.log.WithField("pod", obj.Name).WithField("namespace", obj.Namespace)
 }
 
-func (w *Watcher) eventMatchesCriteria(event *corev1.Event) bool {
+type eventMatch int
+
+const (
+	eventIgnored eventMatch = iota
+	eventMatches
+	// eventPending means the event is about a pod that has not been seen yet.
+	eventPending
+)
+
+// observePod records the labels of every pod (matching or not) and
+// processes events that were held back until the pod was seen.
+func (w *Watcher) observePod(ctx context.Context, pod *corev1.Pod, deleted bool) {
+	if w.pods == nil {
+		return
+	}
+
+	for _, event := range w.pods.observe(pod, deleted) {
+		w.processEvent(ctx, event, false)
+	}
+}
+
+func (w *Watcher) processEvent(ctx context.Context, event *corev1.Event, allowBuffering bool) {
+	switch w.eventMatchesCriteria(event, allowBuffering) {
+	case eventMatches:
+		w.dumpEvent(ctx, event)
+	case eventPending:
+		w.pods.buffer(event)
+	}
+}
+
+// processExpiredEvents handles events whose pods did not show up in time.
+func (w *Watcher) processExpiredEvents(ctx context.Context, now time.Time, force bool) {
+	if w.pods == nil {
+		return
+	}
+
+	for _, event := range w.pods.expire(now, force) {
+		w.processEvent(ctx, event, false)
+	}
+}
+
+func (w *Watcher) eventMatchesCriteria(event *corev1.Event, allowBuffering bool) eventMatch {
 	obj := event.InvolvedObject
 
+	// events for nodes that host matching pods are always interesting
+	if obj.Kind == "Node" && w.opt.WatchNodes && w.nodes.isRelevant(obj.Name) {
+		return eventMatches
+	}
+
 	if obj.Kind != "Pod" || obj.APIVersion != "v1" {
-		w.log.Debug("Involved object is not a Pod.")
-		return false
+		if w.otherEventMatchesCriteria(event) {
+			return eventMatches
+		}
+
+		return eventIgnored
 	}
 
 	eventLog := w.getEventLog(obj)
@@ -260,10 +758,59 @@ func (w *Watcher) eventMatchesCriteria(event *corev1.Event) bool {
 	dummyPod.Name = obj.Name
 	dummyPod.Namespace = obj.Namespace
 
-	// Without fetching the object and hoping it still exists, we cannot compare the labels, so for
-	// events we simply ignore the label selector :grim:
+	if !w.resourceNameMatches(eventLog, dummyPod) || !w.resourceNamespaceMatches(eventLog, dummyPod) {
+		return eventIgnored
+	}
+
+	// no label selector to apply
+	if w.pods == nil {
+		return eventMatches
+	}
+
+	podLabels, known := w.pods.labels(obj.UID)
+	if !known {
+		if allowBuffering && w.bufferEvents && obj.UID != "" {
+			eventLog.Debug("Pod is not known yet, holding back event.")
+			return eventPending
+		}
+
+		// the pod is truly unknown (e.g. it was deleted before protokol
+		// started), so the label selector cannot be applied
+		eventLog.Debug("Pod is unknown, ignoring label selector.")
+		return eventMatches
+	}
+
+	dummyPod.Labels = podLabels
+	if !w.resourceLabelsMatches(eventLog, dummyPod) {
+		return eventIgnored
+	}
+
+	return eventMatches
+}
+
+// otherEventMatchesCriteria checks events for objects that are not Pods.
+func (w *Watcher) otherEventMatchesCriteria(event *corev1.Event) bool {
+	obj := event.InvolvedObject
+
+	if len(w.opt.EventKinds) == 0 {
+		w.log.Debug("Involved object is not a Pod.")
+		return false
+	}
+
+	eventLog := w.getEventLog(obj)
+
+	if !needleMatchesPatterns(obj.Kind, w.opt.EventKinds) {
+		eventLog.Debug("Involved object kind does not match.")
+		return false
+	}
+
+	// cluster-scoped objects like Nodes cannot be filtered by namespace
+	if obj.Namespace != "" && !needleMatchesPatterns(obj.Namespace, w.opt.Namespaces) {
+		eventLog.Debug("Involved object namespace does not match.")
+		return false
+	}
 
-	return w.resourceNameMatches(eventLog, dummyPod) && w.resourceNamespaceMatches(eventLog, dummyPod)
+	return true
 }
 
 func (w *Watcher) resourceNameMatches(log logrus.FieldLogger, pod *corev1.Pod) bool {
@@ -0,0 +1,1533 @@
+// SPDX-FileCopyrightText: 2023 Christoph Mewes
+// SPDX-License-Identifier: MIT
+
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	goruntime "runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"go.xrstf.de/protokol/pkg/collector"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeLogStreamer struct {
+	lock     sync.Mutex
+	logs     map[string]string
+	requests []string
+	// follow makes all streams block until the context is cancelled,
+	// like a real log stream that is being followed
+	follow bool
+}
+
+func (s *fakeLogStreamer) StreamLogs(ctx context.Context, namespace string, podName string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := fmt.Sprintf("%s/%s/%s", namespace, podName, opts.Container)
+	if opts.Previous {
+		key += "/previous"
+	}
+
+	s.requests = append(s.requests, fmt.Sprintf("%s follow=%v", key, opts.Follow))
+
+	if s.follow {
+		reader, writer := io.Pipe()
+
+		go func() {
+			_, _ = writer.Write([]byte(s.logs[key]))
+			<-ctx.Done()
+			writer.CloseWithError(ctx.Err())
+		}()
+
+		return reader, nil
+	}
+
+	return io.NopCloser(strings.NewReader(s.logs[key])), nil
+}
+
+func (s *fakeLogStreamer) StreamNodeLogs(ctx context.Context, nodeName string, query string, since time.Time) (io.ReadCloser, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	key := fmt.Sprintf("node/%s/%s", nodeName, query)
+	s.requests = append(s.requests, fmt.Sprintf("%s since=%s", key, since.UTC().Format(time.RFC3339)))
+
+	content, exists := s.logs[key]
+	if !exists {
+		return nil, apierrors.NewForbidden(schema.GroupResource{Resource: "nodes/proxy"}, nodeName, errors.New("access denied"))
+	}
+
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+type fakeCollector struct {
+	lock      sync.Mutex
+	logs      []string
+	events    []string
+	metadata  []string
+	timeline  []string
+	nodes     []string
+	nodeLogs  []string
+	incidents []string
+}
+
+func (c *fakeCollector) Close() error {
+	return nil
+}
+
+func (c *fakeCollector) CollectTimeline(ctx context.Context, pod *corev1.Pod, entries []collector.TimelineEntry) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, entry := range entries {
+		// omit the timestamp to keep tests stable
+		_, description, _ := strings.Cut(entry.String(), ": ")
+		c.timeline = append(c.timeline, fmt.Sprintf("%s/%s: %s", pod.Namespace, pod.Name, description))
+	}
+
+	return nil
+}
+
+func (c *fakeCollector) CollectNodeTimeline(ctx context.Context, node *corev1.Node, entries []collector.TimelineEntry) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, entry := range entries {
+		_, description, _ := strings.Cut(entry.String(), ": ")
+		c.nodes = append(c.nodes, fmt.Sprintf("%s: %s", node.Name, description))
+	}
+
+	return nil
+}
+
+func (c *fakeCollector) CollectPodMetadata(ctx context.Context, pod *corev1.Pod) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.metadata = append(c.metadata, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+
+	return nil
+}
+
+func (c *fakeCollector) CollectEvent(ctx context.Context, event *corev1.Event) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.events = append(c.events, event.Name)
+
+	return nil
+}
+
+func (c *fakeCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error {
+	content, err := io.ReadAll(stream)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.logs = append(c.logs, fmt.Sprintf("%s/%s/%s#%d: %s", pod.Namespace, pod.Name, containerName, getRestartCount(pod, containerName), string(content)))
+
+	return nil
+}
+
+func (c *fakeCollector) CollectNodeLogs(ctx context.Context, log logrus.FieldLogger, nodeName string, logName string, stream io.Reader) error {
+	content, err := io.ReadAll(stream)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.nodeLogs = append(c.nodeLogs, fmt.Sprintf("%s/%s: %s", nodeName, logName, string(content)))
+
+	return nil
+}
+
+func (c *fakeCollector) CollectIncident(ctx context.Context, incident *collector.Incident) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	exitCode := "none"
+	if incident.ExitCode != nil {
+		exitCode = fmt.Sprint(*incident.ExitCode)
+	}
+
+	var events []string
+	for _, event := range incident.Events {
+		events = append(events, event.Name)
+	}
+
+	nodeName := ""
+	if incident.Node != nil {
+		nodeName = incident.Node.Name
+	}
+
+	c.incidents = append(c.incidents, fmt.Sprintf("%s/%s/%s#%d exit=%s logs=%q events=%v node=%s",
+		incident.Pod.Namespace, incident.Pod.Name, incident.Container, incident.RestartCount, exitCode, incident.Logs, events, nodeName))
+
+	return nil
+}
+
+func (c *fakeCollector) sortedLogs() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	result := append([]string{}, c.logs...)
+	sort.Strings(result)
+
+	return result
+}
+
+func getRestartCount(pod *corev1.Pod, containerName string) int32 {
+	for _, s := range pod.Status.ContainerStatuses {
+		if s.Name == containerName {
+			return s.RestartCount
+		}
+	}
+
+	return 0
+}
+
+func newTestLogger() logrus.FieldLogger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	return log
+}
+
+type podOption func(pod *corev1.Pod)
+
+func withLabels(l map[string]string) podOption {
+	return func(pod *corev1.Pod) {
+		pod.Labels = l
+	}
+}
+
+func withContainer(name string, state corev1.ContainerState, restarts int32) podOption {
+	return func(pod *corev1.Pod) {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: name})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:         name,
+			State:        state,
+			RestartCount: restarts,
+		})
+	}
+}
+
+func withPhase(phase corev1.PodPhase) podOption {
+	return func(pod *corev1.Pod) {
+		pod.Status.Phase = phase
+	}
+}
+
+func withSpecOnlyContainer(name string) podOption {
+	return func(pod *corev1.Pod) {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: name})
+	}
+}
+
+var (
+	running    = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	terminated = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1}}
+	waiting    = corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}
+)
+
+func newPod(namespace, name string, opts ...podOption) corev1.Pod {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(&pod)
+	}
+
+	return pod
+}
+
+func newPodEvent(namespace, podName, eventName string) corev1.Event {
+	return corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      eventName,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Namespace:  namespace,
+			Name:       podName,
+		},
+	}
+}
+
+func toUnstructured(t *testing.T, obj interface{}) *unstructured.Unstructured {
+	t.Helper()
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatalf("Failed to convert object: %v", err)
+	}
+
+	return &unstructured.Unstructured{Object: content}
+}
+
+func assertStrings(t *testing.T, kind string, expected []string, actual []string) {
+	t.Helper()
+
+	if len(expected) == 0 && len(actual) == 0 {
+		return
+	}
+
+	if strings.Join(expected, "\n") != strings.Join(actual, "\n") {
+		t.Fatalf("Unexpected %s.\nExpected:\n  %s\nActual:\n  %s", kind, strings.Join(expected, "\n  "), strings.Join(actual, "\n  "))
+	}
+}
+
+func TestInitialPodMatching(t *testing.T) {
+	testcases := []struct {
+		name     string
+		opt      Options
+		pods     []corev1.Pod
+		expected []string
+	}{
+		{
+			name: "no criteria matches everything that is not waiting",
+			pods: []corev1.Pod{
+				newPod("default", "a", withContainer("app", running, 0)),
+				newPod("default", "b", withContainer("app", terminated, 0)),
+				newPod("default", "c", withContainer("app", waiting, 0)),
+				newPod("default", "d", withSpecOnlyContainer("app")),
+			},
+			expected: []string{
+				"default/a/app#0: a-app",
+				"default/b/app#0: b-app",
+			},
+		},
+		{
+			name: "resource name patterns",
+			opt: Options{
+				ResourceNames: []string{"kube-*", "etcd"},
+			},
+			pods: []corev1.Pod{
+				newPod("default", "kube-apiserver", withContainer("app", running, 0)),
+				newPod("default", "etcd", withContainer("app", running, 0)),
+				newPod("default", "etcd-2", withContainer("app", running, 0)),
+			},
+			expected: []string{
+				"default/etcd/app#0: etcd-app",
+				"default/kube-apiserver/app#0: kube-apiserver-app",
+			},
+		},
+		{
+			name: "namespace patterns",
+			opt: Options{
+				Namespaces: []string{"cluster-*"},
+			},
+			pods: []corev1.Pod{
+				newPod("default", "a", withContainer("app", running, 0)),
+				newPod("cluster-xyz", "b", withContainer("app", running, 0)),
+			},
+			expected: []string{
+				"cluster-xyz/b/app#0: b-app",
+			},
+		},
+		{
+			name: "label selector",
+			opt: Options{
+				LabelSelector: labels.SelectorFromSet(labels.Set{"app": "foo"}),
+			},
+			pods: []corev1.Pod{
+				newPod("default", "a", withLabels(map[string]string{"app": "foo"}), withContainer("app", running, 0)),
+				newPod("default", "b", withLabels(map[string]string{"app": "bar"}), withContainer("app", running, 0)),
+				newPod("default", "c", withContainer("app", running, 0)),
+			},
+			expected: []string{
+				"default/a/app#0: a-app",
+			},
+		},
+		{
+			name: "container name patterns",
+			opt: Options{
+				ContainerNames: []string{"test*"},
+			},
+			pods: []corev1.Pod{
+				newPod("default", "a", withContainer("app", running, 0), withContainer("tester", running, 0)),
+			},
+			expected: []string{
+				"default/a/tester#0: a-tester",
+			},
+		},
+		{
+			name: "running only",
+			opt: Options{
+				RunningOnly: true,
+			},
+			pods: []corev1.Pod{
+				newPod("default", "a", withContainer("app", running, 0)),
+				newPod("default", "b", withContainer("app", terminated, 0)),
+			},
+			expected: []string{
+				"default/a/app#0: a-app",
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			streamer := &fakeLogStreamer{logs: map[string]string{}}
+			for _, pod := range tc.pods {
+				for _, c := range pod.Spec.Containers {
+					streamer.logs[fmt.Sprintf("%s/%s/%s", pod.Namespace, pod.Name, c.Name)] = fmt.Sprintf("%s-%s", pod.Name, c.Name)
+				}
+			}
+
+			coll := &fakeCollector{}
+			tc.opt.OneShot = true
+
+			w := NewWatcher(streamer, coll, newTestLogger(), tc.pods, nil, nil, tc.opt)
+			w.Watch(context.Background(), nil, nil, nil)
+
+			assertStrings(t, "logs", tc.expected, coll.sortedLogs())
+		})
+	}
+}
+
+func TestIncarnations(t *testing.T) {
+	streamer := &fakeLogStreamer{logs: map[string]string{}}
+	coll := &fakeCollector{}
+	podWatcher := watch.NewFake()
+
+	w := NewWatcher(streamer, coll, newTestLogger(), nil, nil, nil, Options{})
+
+	done := make(chan struct{})
+	go func() {
+		w.Watch(context.Background(), podWatcher, nil, nil)
+		close(done)
+	}()
+
+	updates := []corev1.Pod{
+		// container is not ready yet
+		newPod("default", "a", withContainer("app", waiting, 0)),
+		// first incarnation
+		newPod("default", "a", withContainer("app", running, 0)),
+		// an unrelated update must not start a second collector
+		newPod("default", "a", withContainer("app", running, 0)),
+		// container terminates
+		newPod("default", "a", withContainer("app", terminated, 0)),
+		// container is restarted
+		newPod("default", "a", withContainer("app", running, 1)),
+		newPod("default", "a", withContainer("app", running, 1)),
+		// container is restarted again
+		newPod("default", "a", withContainer("app", running, 2)),
+	}
+
+	for i := range updates {
+		podWatcher.Modify(toUnstructured(t, &updates[i]))
+	}
+
+	// objects that cannot be converted are ignored
+	podWatcher.Modify(&corev1.Pod{})
+
+	podWatcher.Stop()
+	<-done
+
+	assertStrings(t, "logs", []string{
+		"default/a/app#0: ",
+		"default/a/app#1: ",
+		"default/a/app#2: ",
+	}, coll.sortedLogs())
+}
+
+func TestOneShot(t *testing.T) {
+	testcases := []struct {
+		oneShot  bool
+		expected string
+	}{
+		{oneShot: true, expected: "default/a/app follow=false"},
+		{oneShot: false, expected: "default/a/app follow=true"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(fmt.Sprintf("oneshot=%v", tc.oneShot), func(t *testing.T) {
+			streamer := &fakeLogStreamer{logs: map[string]string{}}
+			coll := &fakeCollector{}
+			pods := []corev1.Pod{newPod("default", "a", withContainer("app", running, 0))}
+
+			w := NewWatcher(streamer, coll, newTestLogger(), pods, nil, nil, Options{OneShot: tc.oneShot})
+			w.Watch(context.Background(), nil, nil, nil)
+
+			assertStrings(t, "log requests", []string{tc.expected}, streamer.requests)
+		})
+	}
+}
+
+func TestClientsetLogStreamer(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	coll := &fakeCollector{}
+	pods := []corev1.Pod{newPod("default", "a", withContainer("app", running, 0))}
+
+	w := NewWatcher(NewLogStreamer(clientset), coll, newTestLogger(), pods, nil, nil, Options{OneShot: true})
+	w.Watch(context.Background(), nil, nil, nil)
+
+	// the fake clientset always returns this static string as logs
+	assertStrings(t, "logs", []string{"default/a/app#0: fake logs"}, coll.sortedLogs())
+}
+
+func TestMetadataDumping(t *testing.T) {
+	testcases := []struct {
+		dumpMetadata bool
+		expected     []string
+	}{
+		{dumpMetadata: true, expected: []string{"default/a"}},
+		{dumpMetadata: false, expected: nil},
+	}
+
+	for _, tc := range testcases {
+		t.Run(fmt.Sprintf("metadata=%v", tc.dumpMetadata), func(t *testing.T) {
+			streamer := &fakeLogStreamer{logs: map[string]string{}}
+			coll := &fakeCollector{}
+			pods := []corev1.Pod{
+				newPod("default", "a", withContainer("app", running, 0)),
+				newPod("kube-system", "b", withContainer("app", running, 0)),
+			}
+
+			w := NewWatcher(streamer, coll, newTestLogger(), pods, nil, nil, Options{
+				OneShot:      true,
+				DumpMetadata: tc.dumpMetadata,
+				Namespaces:   []string{"default"},
+			})
+			w.Watch(context.Background(), nil, nil, nil)
+
+			assertStrings(t, "metadata", tc.expected, coll.metadata)
+		})
+	}
+}
+
+func TestEventDumping(t *testing.T) {
+	nonPodEvent := newPodEvent("default", "my-deployment", "deployment-event")
+	nonPodEvent.InvolvedObject.APIVersion = "apps/v1"
+	nonPodEvent.InvolvedObject.Kind = "Deployment"
+
+	otherNamespaceEvent := newPodEvent("kube-system", "my-pvc", "pvc-event")
+	otherNamespaceEvent.InvolvedObject.Kind = "PersistentVolumeClaim"
+
+	nodeEvent := newPodEvent("", "my-node", "node-event")
+	nodeEvent.InvolvedObject.Kind = "Node"
+
+	initialEvents := []corev1.Event{
+		newPodEvent("default", "a", "initial-a"),
+		newPodEvent("kube-system", "a", "initial-other-namespace"),
+		newPodEvent("default", "b", "initial-b"),
+		nonPodEvent,
+		otherNamespaceEvent,
+		nodeEvent,
+	}
+
+	testcases := []struct {
+		name       string
+		dumpEvents bool
+		eventKinds []string
+		expected   []string
+	}{
+		{
+			name:       "events are dumped",
+			dumpEvents: true,
+			expected:   []string{"initial-a", "watched-a"},
+		},
+		{
+			name:       "events are not dumped",
+			dumpEvents: false,
+			expected:   nil,
+		},
+		{
+			name:       "events for other kinds are dumped",
+			dumpEvents: true,
+			eventKinds: []string{"Deploy*", "Node"},
+			expected:   []string{"initial-a", "deployment-event", "node-event", "watched-a"},
+		},
+		{
+			name:       "events for all kinds are dumped",
+			dumpEvents: true,
+			eventKinds: []string{"*"},
+			expected:   []string{"initial-a", "deployment-event", "node-event", "watched-a"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			streamer := &fakeLogStreamer{logs: map[string]string{}}
+			coll := &fakeCollector{}
+			eventWatcher := watch.NewFake()
+
+			w := NewWatcher(streamer, coll, newTestLogger(), nil, initialEvents, nil, Options{
+				DumpEvents:    tc.dumpEvents,
+				EventKinds:    tc.eventKinds,
+				Namespaces:    []string{"default"},
+				ResourceNames: []string{"a"},
+			})
+
+			done := make(chan struct{})
+			go func() {
+				w.Watch(context.Background(), nil, eventWatcher, nil)
+				close(done)
+			}()
+
+			// events can be watched using either of the two event APIs
+			watchedEvent := newPodEvent("default", "a", "watched-a")
+			newStyleEvent := toUnstructured(t, &eventsv1.Event{
+				ObjectMeta: watchedEvent.ObjectMeta,
+				Regarding:  watchedEvent.InvolvedObject,
+			})
+			newStyleEvent.SetAPIVersion("events.k8s.io/v1")
+			newStyleEvent.SetKind("Event")
+			eventWatcher.Add(newStyleEvent)
+
+			ignoredEvent := newPodEvent("default", "b", "watched-b")
+			eventWatcher.Add(toUnstructured(t, &ignoredEvent))
+
+			eventWatcher.Stop()
+			<-done
+
+			assertStrings(t, "events", tc.expected, coll.events)
+		})
+	}
+}
+
+func TestStopConditions(t *testing.T) {
+	testcases := []struct {
+		name        string
+		conditions  StopConditions
+		initialPods []corev1.Pod
+		updates     []corev1.Pod
+		expected    Result
+	}{
+		{
+			name:       "all pods terminated successfully",
+			conditions: StopConditions{AllTerminated: true},
+			updates: []corev1.Pod{
+				newPod("default", "a", withPhase(corev1.PodRunning)),
+				newPod("default", "b", withPhase(corev1.PodRunning)),
+				newPod("default", "a", withPhase(corev1.PodSucceeded)),
+				newPod("default", "b", withPhase(corev1.PodSucceeded)),
+			},
+			expected: Result{StopReason: "all pods have terminated"},
+		},
+		{
+			name:       "all pods terminated, one failed",
+			conditions: StopConditions{AllTerminated: true},
+			updates: []corev1.Pod{
+				newPod("default", "a", withPhase(corev1.PodRunning)),
+				newPod("default", "b", withPhase(corev1.PodRunning)),
+				newPod("default", "a", withPhase(corev1.PodFailed)),
+				newPod("default", "b", withPhase(corev1.PodSucceeded)),
+			},
+			expected: Result{StopReason: "all pods have terminated", PodsFailed: true},
+		},
+		{
+			name:       "initial pods are evaluated together",
+			conditions: StopConditions{AllTerminated: true},
+			initialPods: []corev1.Pod{
+				newPod("default", "a", withPhase(corev1.PodSucceeded)),
+				newPod("default", "b", withPhase(corev1.PodRunning)),
+			},
+			updates: []corev1.Pod{
+				newPod("default", "b", withPhase(corev1.PodFailed)),
+			},
+			expected: Result{StopReason: "all pods have terminated", PodsFailed: true},
+		},
+		{
+			name:       "completion of a specific pod",
+			conditions: StopConditions{Completion: labels.SelectorFromSet(labels.Set{"job": "test"})},
+			updates: []corev1.Pod{
+				newPod("default", "a", withPhase(corev1.PodRunning)),
+				newPod("default", "b", withLabels(map[string]string{"job": "test"}), withPhase(corev1.PodRunning)),
+				newPod("default", "a", withPhase(corev1.PodFailed)),
+				newPod("default", "b", withLabels(map[string]string{"job": "test"}), withPhase(corev1.PodSucceeded)),
+			},
+			expected: Result{StopReason: "pod default/b has Succeeded"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			streamer := &fakeLogStreamer{logs: map[string]string{}}
+			coll := &fakeCollector{}
+			// buffered, so that sending updates does not block if Watch stops early
+			podWatcher := watch.NewFakeWithChanSize(len(tc.updates), false)
+
+			w := NewWatcher(streamer, coll, newTestLogger(), tc.initialPods, nil, nil, Options{StopConditions: tc.conditions})
+
+			results := make(chan Result)
+			go func() {
+				results <- w.Watch(context.Background(), podWatcher, nil, nil)
+			}()
+
+			for i := range tc.updates {
+				podWatcher.Modify(toUnstructured(t, &tc.updates[i]))
+			}
+
+			// Watch must return on its own, without the watch being stopped
+			result := <-results
+
+			if result.StopReason != tc.expected.StopReason || result.PodsFailed != tc.expected.PodsFailed {
+				t.Fatalf("Expected result %+v, got %+v.", tc.expected, result)
+			}
+
+			if !podWatcher.IsStopped() {
+				t.Fatal("Expected pod watch to be stopped.")
+			}
+		})
+	}
+}
+
+func TestIdleStopCondition(t *testing.T) {
+	now := time.Now()
+	tracker := newStopTracker(StopConditions{Idle: time.Minute})
+	tracker.lastActive = now
+
+	running := newPod("default", "a", withPhase(corev1.PodRunning))
+	if result := tracker.update(&running, false); result != nil {
+		t.Fatalf("Expected no result, got %+v.", result)
+	}
+
+	if result := tracker.check(now.Add(2 * time.Minute)); result != nil {
+		t.Fatalf("Expected no result while pods are active, got %+v.", result)
+	}
+
+	// deleting the last active pod starts the idle timer
+	now = now.Add(2 * time.Minute)
+	tracker.lastActive = now
+
+	if result := tracker.update(&running, true); result != nil {
+		t.Fatalf("Expected no result, got %+v.", result)
+	}
+
+	if result := tracker.check(now.Add(30 * time.Second)); result != nil {
+		t.Fatalf("Expected no result before idle timeout, got %+v.", result)
+	}
+
+	if result := tracker.check(now.Add(time.Minute)); result == nil {
+		t.Fatal("Expected stop condition to be met after idle timeout.")
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	testcases := []struct {
+		name        string
+		timeout     time.Duration
+		idleTimeout time.Duration
+		expected    string
+	}{
+		{
+			name:     "overall timeout",
+			timeout:  200 * time.Millisecond,
+			expected: "timeout reached",
+		},
+		{
+			name:        "idle timeout",
+			idleTimeout: 200 * time.Millisecond,
+			expected:    "no activity for 200ms",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeoutCause(ctx, tc.timeout, errors.New("timeout reached"))
+				defer cancel()
+			}
+
+			streamer := &fakeLogStreamer{logs: map[string]string{"default/a/app": "hello"}, follow: true}
+			coll := &fakeCollector{}
+			podWatcher := watch.NewFake()
+			eventWatcher := watch.NewFake()
+			pods := []corev1.Pod{newPod("default", "a", withContainer("app", running, 0))}
+
+			w := NewWatcher(streamer, coll, newTestLogger(), pods, nil, nil, Options{IdleTimeout: tc.idleTimeout})
+
+			// neither the watches nor the log stream end on their own
+			result := w.Watch(ctx, podWatcher, eventWatcher, nil)
+
+			if result.StopReason != tc.expected {
+				t.Fatalf("Expected stop reason %q, got %q.", tc.expected, result.StopReason)
+			}
+
+			if !podWatcher.IsStopped() || !eventWatcher.IsStopped() {
+				t.Fatal("Expected watches to be stopped.")
+			}
+
+			// the stream was cancelled, so the fake collector could not finish reading it
+			assertStrings(t, "logs", nil, coll.sortedLogs())
+		})
+	}
+}
+
+func TestFailureDetection(t *testing.T) {
+	oomKilled := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}}
+	succeeded := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Reason: "Completed"}}
+
+	// without a time, the termination might have happened during the watch
+	crashedBefore := newPod("default", "restarted", withContainer("app", running, 4))
+	crashedBefore.Status.ContainerStatuses[0].LastTerminationState = terminated
+
+	// crashes that happened before the watch started do not count
+	longAgo := metav1.NewTime(time.Now().Add(-time.Hour))
+	crashedLongAgo := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, FinishedAt: longAgo}}
+
+	recovered := newPod("default", "recovered", withContainer("app", running, 1))
+	recovered.Status.ContainerStatuses[0].LastTerminationState = crashedLongAgo
+
+	// containers that are killed because their pod is deleted did not crash
+	now := metav1.Now()
+	killed := newPod("default", "deleted", withContainer("app", corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 143, Reason: "Error"}}, 0))
+	killed.DeletionTimestamp = &now
+
+	initialPods := []corev1.Pod{
+		newPod("default", "healthy", withContainer("app", running, 0)),
+		newPod("default", "succeeded", withContainer("app", succeeded, 0)),
+		newPod("default", "crashed", withContainer("app", terminated, 0), withContainer("ignored", terminated, 0)),
+		newPod("default", "restarting", withContainer("app", running, 3)),
+		newPod("default", "deleted", withContainer("app", running, 0)),
+		newPod("default", "finished", withContainer("app", crashedLongAgo, 0)),
+		recovered,
+		crashedBefore,
+	}
+
+	updates := []corev1.Pod{
+		newPod("default", "healthy", withContainer("app", oomKilled, 0)),
+		// restarts before protokol was started do not count
+		newPod("default", "restarting", withContainer("app", running, 5)),
+		killed,
+	}
+
+	streamer := &fakeLogStreamer{logs: map[string]string{}}
+	coll := &fakeCollector{}
+	podWatcher := watch.NewFake()
+
+	w := NewWatcher(streamer, coll, newTestLogger(), initialPods, nil, nil, Options{
+		ContainerNames: []string{"app"},
+		DetectFailures: true,
+		MaxRestarts:    1,
+	})
+
+	results := make(chan Result)
+	go func() {
+		results <- w.Watch(context.Background(), podWatcher, nil, nil)
+	}()
+
+	for i := range updates {
+		podWatcher.Modify(toUnstructured(t, &updates[i]))
+	}
+
+	podWatcher.Delete(toUnstructured(t, &killed))
+
+	podWatcher.Stop()
+	result := <-results
+
+	var failures []string
+	for _, f := range result.Failures {
+		failures = append(failures, fmt.Sprintf("%s/%s/%s exit=%d reason=%s restarts=%d", f.Namespace, f.Pod, f.Container, f.ExitCode, f.Reason, f.Restarts))
+	}
+
+	assertStrings(t, "failures", []string{
+		"default/crashed/app exit=1 reason= restarts=0",
+		"default/healthy/app exit=137 reason=OOMKilled restarts=0",
+		"default/restarted/app exit=1 reason= restarts=0",
+		"default/restarting/app exit=0 reason= restarts=2",
+	}, failures)
+}
+
+func TestTimeline(t *testing.T) {
+	now := metav1.Now()
+
+	withCondition := func(condition corev1.PodConditionType, status corev1.ConditionStatus) podOption {
+		return func(pod *corev1.Pod) {
+			pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{
+				Type:               condition,
+				Status:             status,
+				LastTransitionTime: now,
+			})
+		}
+	}
+
+	imagePullBackOff := corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}
+	oomKilled := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled", FinishedAt: now}}
+
+	restarted := newPod("default", "a", withPhase(corev1.PodRunning), withCondition(corev1.PodScheduled, corev1.ConditionTrue), withContainer("app", running, 1))
+	restarted.Status.ContainerStatuses[0].LastTerminationState = oomKilled
+
+	deleting := restarted.DeepCopy()
+	deleting.DeletionTimestamp = &now
+
+	updates := []corev1.Pod{
+		newPod("default", "a", withPhase(corev1.PodPending), withCondition(corev1.PodScheduled, corev1.ConditionTrue)),
+		newPod("default", "a", withPhase(corev1.PodPending), withCondition(corev1.PodScheduled, corev1.ConditionTrue), withContainer("app", waiting, 0)),
+		newPod("default", "a", withPhase(corev1.PodPending), withCondition(corev1.PodScheduled, corev1.ConditionTrue), withContainer("app", imagePullBackOff, 0)),
+		newPod("default", "a", withPhase(corev1.PodRunning), withCondition(corev1.PodScheduled, corev1.ConditionTrue), withContainer("app", running, 0)),
+		// the termination was not observed directly, only after the restart
+		restarted,
+		restarted,
+		*deleting,
+	}
+
+	streamer := &fakeLogStreamer{logs: map[string]string{}}
+	coll := &fakeCollector{}
+	podWatcher := watch.NewFake()
+
+	w := NewWatcher(streamer, coll, newTestLogger(), nil, nil, nil, Options{DumpTimeline: true})
+
+	done := make(chan struct{})
+	go func() {
+		w.Watch(context.Background(), podWatcher, nil, nil)
+		close(done)
+	}()
+
+	for i := range updates {
+		podWatcher.Modify(toUnstructured(t, &updates[i]))
+	}
+
+	podWatcher.Delete(toUnstructured(t, deleting))
+	podWatcher.Stop()
+	<-done
+
+	assertStrings(t, "timeline", []string{
+		"default/a: Phase (reason: Pending)",
+		"default/a: Condition (reason: PodScheduled=True)",
+		"default/a: ContainerWaiting [app] (reason: ContainerCreating)",
+		"default/a: ContainerWaiting [app] (reason: ImagePullBackOff)",
+		"default/a: Phase (reason: Running)",
+		"default/a: ContainerRunning [app]",
+		"default/a: ContainerTerminated [app] (reason: OOMKilled, exit code: 137)",
+		"default/a: ContainerRestarted [app] restart count is now 1",
+		"default/a: DeletionRequested",
+		"default/a: Deleted",
+	}, coll.timeline)
+}
+
+func TestPodDeletion(t *testing.T) {
+	streamer := &fakeLogStreamer{logs: map[string]string{}}
+	coll := &fakeCollector{}
+	podWatcher := watch.NewFake()
+
+	w := NewWatcher(streamer, coll, newTestLogger(), nil, nil, nil, Options{DumpMetadata: true, DetectFailures: true})
+
+	done := make(chan struct{})
+	go func() {
+		w.Watch(context.Background(), podWatcher, nil, nil)
+		close(done)
+	}()
+
+	pod := newPod("default", "a", withContainer("app", running, 0))
+	podWatcher.Add(toUnstructured(t, &pod))
+	podWatcher.Delete(toUnstructured(t, &pod))
+
+	// recreating a pod with the same name after it was deleted must start a new collector
+	podWatcher.Add(toUnstructured(t, &pod))
+
+	other := newPod("default", "b", withContainer("app", running, 0))
+	podWatcher.Add(toUnstructured(t, &other))
+
+	podWatcher.Stop()
+	<-done
+
+	assertStrings(t, "logs", []string{
+		"default/a/app#0: ",
+		"default/a/app#0: ",
+		"default/b/app#0: ",
+	}, coll.sortedLogs())
+
+	// initial state, final state, recreated pod, other pod
+	assertStrings(t, "metadata", []string{"default/a", "default/a", "default/a", "default/b"}, coll.metadata)
+
+	if _, exists := w.seenContainers["default/a"]; !exists {
+		t.Error("Expected recreated pod to be tracked.")
+	}
+
+	if len(w.seenContainers) != 2 || len(w.podCollectors) != 2 {
+		t.Errorf("Expected exactly two pods to be tracked, but got %d seen and %d collectors.", len(w.seenContainers), len(w.podCollectors))
+	}
+
+	if len(w.failures.containers) != 2 {
+		t.Errorf("Expected the failure tracker to only know two containers, but got %d.", len(w.failures.containers))
+	}
+}
+
+func TestReusedPodNames(t *testing.T) {
+	streamer := &fakeLogStreamer{logs: map[string]string{}}
+	coll := &fakeCollector{}
+	podWatcher := watch.NewFake()
+
+	w := NewWatcher(streamer, coll, newTestLogger(), nil, nil, nil, Options{})
+
+	done := make(chan struct{})
+	go func() {
+		w.Watch(context.Background(), podWatcher, nil, nil)
+		close(done)
+	}()
+
+	first := newPod("default", "sts-0", withContainer("app", running, 0))
+	first.UID = "uid-1"
+
+	second := first.DeepCopy()
+	second.UID = "uid-2"
+
+	// the deletion of the first pod is never observed (e.g. because of a
+	// watch reconnect), still the second pod must be recognized as new
+	podWatcher.Add(toUnstructured(t, &first))
+	podWatcher.Add(toUnstructured(t, second))
+	podWatcher.Stop()
+	<-done
+
+	assertStrings(t, "logs", []string{
+		"default/sts-0/app#0: ",
+		"default/sts-0/app#0: ",
+	}, coll.sortedLogs())
+}
+
+func TestEventLabelSelector(t *testing.T) {
+	withUID := func(uid types.UID) podOption {
+		return func(pod *corev1.Pod) {
+			pod.UID = uid
+		}
+	}
+
+	eventFor := func(podName string, uid types.UID, eventName string) corev1.Event {
+		event := newPodEvent("default", podName, eventName)
+		event.InvolvedObject.UID = uid
+
+		return event
+	}
+
+	initialPods := []corev1.Pod{
+		newPod("default", "a", withUID("uid-a"), withLabels(map[string]string{"app": "foo"})),
+		newPod("default", "b", withUID("uid-b"), withLabels(map[string]string{"app": "bar"})),
+	}
+
+	initialEvents := []corev1.Event{
+		eventFor("a", "uid-a", "initial-a"),
+		eventFor("b", "uid-b", "initial-b"),
+	}
+
+	streamer := &fakeLogStreamer{logs: map[string]string{}}
+	coll := &fakeCollector{}
+	podWatcher := watch.NewFake()
+	eventWatcher := watch.NewFake()
+
+	w := NewWatcher(streamer, coll, newTestLogger(), initialPods, initialEvents, nil, Options{
+		DumpEvents:    true,
+		LabelSelector: labels.SelectorFromSet(labels.Set{"app": "foo"}),
+	})
+
+	done := make(chan struct{})
+	go func() {
+		w.Watch(context.Background(), podWatcher, eventWatcher, nil)
+		close(done)
+	}()
+
+	// events for pods that are not known yet are held back until the pod shows up
+	for _, event := range []corev1.Event{
+		eventFor("c", "uid-c", "early-c"),
+		eventFor("d", "uid-d", "early-d"),
+		eventFor("e", "uid-e", "unknown-e"),
+	} {
+		eventWatcher.Add(toUnstructured(t, &event))
+	}
+
+	podC := newPod("default", "c", withUID("uid-c"), withLabels(map[string]string{"app": "foo"}))
+	podWatcher.Add(toUnstructured(t, &podC))
+
+	podD := newPod("default", "d", withUID("uid-d"), withLabels(map[string]string{"app": "bar"}))
+	podWatcher.Add(toUnstructured(t, &podD))
+
+	// events for pods that are already known are processed immediately
+	lateC := eventFor("c", "uid-c", "late-c")
+	eventWatcher.Add(toUnstructured(t, &lateC))
+
+	lateD := eventFor("d", "uid-d", "late-d")
+	eventWatcher.Add(toUnstructured(t, &lateD))
+
+	// pod e never shows up, so its event is matched by name when shutting down
+	podWatcher.Stop()
+	eventWatcher.Stop()
+	<-done
+
+	sort.Strings(coll.events)
+	assertStrings(t, "events", []string{"early-c", "initial-a", "late-c", "unknown-e"}, coll.events)
+}
+
+func TestNodeTracking(t *testing.T) {
+	newNode := func(name string, ready corev1.ConditionStatus) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			},
+		}
+	}
+
+	withNode := func(nodeName string) podOption {
+		return func(pod *corev1.Pod) {
+			pod.Spec.NodeName = nodeName
+		}
+	}
+
+	nodeEvent := func(nodeName string, eventName string) corev1.Event {
+		return corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: eventName},
+			InvolvedObject: corev1.ObjectReference{APIVersion: "v1", Kind: "Node", Name: nodeName},
+		}
+	}
+
+	initialNodes := []corev1.Node{
+		newNode("node-a", corev1.ConditionTrue),
+		newNode("node-b", corev1.ConditionTrue),
+		newNode("node-c", corev1.ConditionFalse),
+	}
+
+	initialPods := []corev1.Pod{
+		newPod("default", "a", withNode("node-a")),
+	}
+
+	streamer := &fakeLogStreamer{logs: map[string]string{}}
+	coll := &fakeCollector{}
+	podWatcher := watch.NewFake()
+	eventWatcher := watch.NewFake()
+	nodeWatcher := watch.NewFake()
+
+	w := NewWatcher(streamer, coll, newTestLogger(), initialPods, nil, initialNodes, Options{
+		DumpEvents: true,
+		WatchNodes: true,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		w.Watch(context.Background(), podWatcher, eventWatcher, nodeWatcher)
+		close(done)
+	}()
+
+	// node-a is relevant from the start
+	cordoned := newNode("node-a", corev1.ConditionTrue)
+	cordoned.Spec.Unschedulable = true
+	cordoned.Spec.Taints = []corev1.Taint{{Key: "node.kubernetes.io/unschedulable", Effect: corev1.TaintEffectNoSchedule}}
+	nodeWatcher.Modify(toUnstructured(t, &cordoned))
+	nodeWatcher.Delete(toUnstructured(t, &cordoned))
+
+	// node-c never hosts a matching pod
+	notReady := newNode("node-c", corev1.ConditionUnknown)
+	nodeWatcher.Modify(toUnstructured(t, &notReady))
+
+	for _, event := range []corev1.Event{nodeEvent("node-a", "event-a"), nodeEvent("node-c", "event-c")} {
+		eventWatcher.Add(toUnstructured(t, &event))
+	}
+
+	// node-b becomes relevant later on
+	podB := newPod("default", "b", withNode("node-b"))
+	podWatcher.Add(toUnstructured(t, &podB))
+
+	podWatcher.Stop()
+	eventWatcher.Stop()
+	nodeWatcher.Stop()
+	<-done
+
+	sort.Strings(coll.nodes)
+	assertStrings(t, "node timeline", []string{
+		"node-a: Condition (reason: Ready=True)",
+		"node-a: Cordoned",
+		"node-a: Deleted",
+		"node-a: TaintAdded (reason: node.kubernetes.io/unschedulable:NoSchedule)",
+		"node-b: Condition (reason: Ready=True)",
+	}, coll.nodes)
+
+	assertStrings(t, "events", []string{"event-a"}, coll.events)
+}
+
+func TestNodeLogs(t *testing.T) {
+	created := func(ts time.Time) podOption {
+		return func(pod *corev1.Pod) {
+			pod.CreationTimestamp = metav1.NewTime(ts)
+		}
+	}
+
+	onNode := func(nodeName string) podOption {
+		return func(pod *corev1.Pod) {
+			pod.Spec.NodeName = nodeName
+		}
+	}
+
+	older := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	initialPods := []corev1.Pod{
+		newPod("default", "a", onNode("node-a"), created(newer)),
+		newPod("default", "b", onNode("node-a"), created(older)),
+		newPod("default", "c", onNode("node-b"), created(newer)),
+		newPod("other", "d", onNode("node-c"), created(newer)),
+	}
+
+	streamer := &fakeLogStreamer{logs: map[string]string{
+		"node/node-a/kubelet":    "kubelet a\n",
+		"node/node-a/containerd": "containerd a\n",
+		"node/node-b/kubelet":    "kubelet b\n",
+	}}
+	coll := &fakeCollector{}
+
+	w := NewWatcher(streamer, coll, newTestLogger(), initialPods, nil, nil, Options{
+		Namespaces: []string{"default"},
+		OneShot:    true,
+		NodeLogs:   []string{"kubelet", "containerd"},
+	})
+
+	w.Watch(context.Background(), nil, nil, nil)
+
+	sort.Strings(coll.nodeLogs)
+	assertStrings(t, "node logs", []string{
+		"node-a/containerd: containerd a\n",
+		"node-a/kubelet: kubelet a\n",
+		"node-b/kubelet: kubelet b\n",
+	}, coll.nodeLogs)
+
+	var nodeRequests []string
+	for _, request := range streamer.requests {
+		if strings.HasPrefix(request, "node/") {
+			nodeRequests = append(nodeRequests, request)
+		}
+	}
+
+	// node-b does not allow access to its containerd logs, node-c is not relevant
+	sort.Strings(nodeRequests)
+	assertStrings(t, "node log requests", []string{
+		"node/node-a/containerd since=2024-01-02T03:04:05Z",
+		"node/node-a/kubelet since=2024-01-02T03:04:05Z",
+		"node/node-b/containerd since=2024-01-02T04:04:05Z",
+		"node/node-b/kubelet since=2024-01-02T04:04:05Z",
+	}, nodeRequests)
+}
+
+type fakeIncidentSource struct {
+	events []corev1.Event
+	nodes  []corev1.Node
+}
+
+func (s *fakeIncidentSource) PodEvents(ctx context.Context, pod *corev1.Pod) ([]corev1.Event, error) {
+	var result []corev1.Event
+	for _, event := range s.events {
+		if event.InvolvedObject.Namespace == pod.Namespace && event.InvolvedObject.Name == pod.Name {
+			result = append(result, event)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *fakeIncidentSource) Node(ctx context.Context, nodeName string) (*corev1.Node, error) {
+	for i, node := range s.nodes {
+		if node.Name == nodeName {
+			return &s.nodes[i], nil
+		}
+	}
+
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "nodes"}, nodeName)
+}
+
+func TestIncidents(t *testing.T) {
+	onNode := func(nodeName string) podOption {
+		return func(pod *corev1.Pod) {
+			pod.Spec.NodeName = nodeName
+		}
+	}
+
+	lastTerminated := func(exitCode int32) podOption {
+		return func(pod *corev1.Pod) {
+			pod.Status.ContainerStatuses[0].LastTerminationState = corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode},
+			}
+		}
+	}
+
+	deleting := func(pod *corev1.Pod) {
+		now := metav1.Now()
+		pod.DeletionTimestamp = &now
+	}
+
+	initialPods := []corev1.Pod{
+		newPod("default", "restarting", onNode("node-a"), withContainer("app", running, 3)),
+		newPod("default", "failing", withContainer("app", running, 0), withContainer("ignored", running, 0)),
+		newPod("default", "crashed", withContainer("app", terminated, 0)),
+		newPod("default", "deleted", withContainer("app", running, 0)),
+	}
+
+	updates := []corev1.Pod{
+		// restarts before protokol was started are not incidents
+		newPod("default", "restarting", onNode("node-a"), withContainer("app", running, 4), lastTerminated(2)),
+		newPod("default", "restarting", onNode("node-a"), withContainer("app", running, 4), lastTerminated(2)),
+		// a failure followed by a restart is only one incident
+		newPod("default", "failing", withContainer("app", terminated, 0), withContainer("ignored", terminated, 0)),
+		newPod("default", "failing", withContainer("app", running, 1), withContainer("ignored", terminated, 0), lastTerminated(1)),
+		// the crash happened before protokol was started
+		newPod("default", "crashed", withContainer("app", running, 1), lastTerminated(1)),
+		newPod("default", "deleted", withContainer("app", terminated, 0), deleting),
+	}
+
+	streamer := &fakeLogStreamer{logs: map[string]string{
+		"default/restarting/app/previous": "panic: boom\n",
+		"default/failing/app":             "fatal error\n",
+	}}
+	coll := &fakeCollector{}
+	podWatcher := watch.NewFake()
+
+	source := &fakeIncidentSource{
+		events: []corev1.Event{
+			newPodEvent("default", "restarting", "back-off"),
+			newPodEvent("default", "failing", "failed"),
+		},
+		nodes: []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}},
+	}
+
+	w := NewWatcher(streamer, coll, newTestLogger(), initialPods, nil, nil, Options{
+		ContainerNames: []string{"app"},
+		Incidents:      source,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		w.Watch(context.Background(), podWatcher, nil, nil)
+		close(done)
+	}()
+
+	for i := range updates {
+		podWatcher.Modify(toUnstructured(t, &updates[i]))
+	}
+
+	podWatcher.Stop()
+	<-done
+
+	sort.Strings(coll.incidents)
+	assertStrings(t, "incidents", []string{
+		`default/failing/app#0 exit=1 logs="fatal error\n" events=[failed] node=`,
+		`default/restarting/app#3 exit=2 logs="panic: boom\n" events=[back-off] node=node-a`,
+	}, coll.incidents)
+}
+
+func TestLineBuffer(t *testing.T) {
+	input := "1\n2\n3\n4\n5"
+
+	testcases := []struct {
+		policy          BufferPolicy
+		expected        string
+		expectedDropped int
+		expectedSpilled int
+	}{
+		{policy: BufferBlock, expected: input},
+		{policy: BufferDropOldest, expected: "4\n5", expectedDropped: 3},
+		{policy: BufferSpill, expected: input, expectedSpilled: 3},
+	}
+
+	for _, tc := range testcases {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			spillDirectory := t.TempDir()
+
+			buffer := newLineBuffer(strings.NewReader(input), BufferOptions{
+				Lines:          2,
+				Policy:         tc.policy,
+				SpillDirectory: spillDirectory,
+			})
+
+			var reader io.Reader = buffer
+
+			// let the buffer fill up before anything is read, except when
+			// blocking, which would never finish
+			if tc.policy != BufferBlock {
+				<-buffer.done
+
+				// a single read returns all buffered and spilled lines
+				p := make([]byte, 100)
+
+				n, err := buffer.Read(p)
+				if err != nil || n != len(tc.expected) {
+					t.Errorf("Expected a single read to return %d bytes, got %d (%v).", len(tc.expected), n, err)
+				}
+
+				reader = io.MultiReader(bytes.NewReader(p[:n]), buffer)
+			}
+
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Failed to read buffer: %v", err)
+			}
+
+			if string(content) != tc.expected {
+				t.Errorf("Expected %q, got %q.", tc.expected, string(content))
+			}
+
+			dropped, spilled, err := buffer.wait()
+			if err != nil {
+				t.Fatalf("Failed to clean up buffer: %v", err)
+			}
+
+			if dropped != tc.expectedDropped || spilled != tc.expectedSpilled {
+				t.Errorf("Expected %d dropped and %d spilled lines, got %d and %d.", tc.expectedDropped, tc.expectedSpilled, dropped, spilled)
+			}
+
+			if files, _ := os.ReadDir(spillDirectory); len(files) > 0 {
+				t.Errorf("Expected spill file to be removed, but found %d files.", len(files))
+			}
+		})
+	}
+}
+
+func TestLineBufferFastReader(t *testing.T) {
+	// the in-memory stream is much faster than any real log stream, so the
+	// pump can fill the buffer whenever the operating system pauses the
+	// reader's thread; with a single thread, the result does not depend on
+	// how busy the machine is
+	defer goruntime.GOMAXPROCS(goruntime.GOMAXPROCS(1))
+
+	input := strings.Repeat("a log line\n", 200000)
+
+	buffer := newLineBuffer(strings.NewReader(input), BufferOptions{
+		Lines:  10000,
+		Policy: BufferDropOldest,
+	})
+
+	read, err := io.Copy(io.Discard, buffer)
+	if err != nil {
+		t.Fatalf("Failed to read buffer: %v", err)
+	}
+
+	dropped, _, err := buffer.wait()
+	if err != nil {
+		t.Fatalf("Failed to clean up buffer: %v", err)
+	}
+
+	// a reader that keeps up must not lose any lines
+	if dropped != 0 || read != int64(len(input)) {
+		t.Errorf("Expected all %d bytes to be read, got %d bytes and %d dropped lines.", len(input), read, dropped)
+	}
+}
+
+// blockedCollector does not read logs until it is unblocked.
+type blockedCollector struct {
+	fakeCollector
+
+	unblock chan struct{}
+}
+
+func (c *blockedCollector) CollectLogs(ctx context.Context, log logrus.FieldLogger, pod *corev1.Pod, containerName string, stream io.Reader) error {
+	<-c.unblock
+	return c.fakeCollector.CollectLogs(ctx, log, pod, containerName, stream)
+}
+
+func TestLineBufferBehindMultiplexer(t *testing.T) {
+	// much more than the multiplexer buffers for each collector
+	input := strings.Repeat("a log line\n", 100000)
+
+	buffer := newLineBuffer(strings.NewReader(input), BufferOptions{
+		Lines:  100,
+		Policy: BufferDropOldest,
+	})
+
+	// both collectors share the channel that unblocks them
+	unblock := make(chan struct{})
+	blocked := []*blockedCollector{{unblock: unblock}, {unblock: unblock}}
+
+	coll, err := collector.NewMultiplexCollector(blocked[0], blocked[1])
+	if err != nil {
+		t.Fatalf("Failed to create collector: %v", err)
+	}
+
+	result := make(chan error)
+	go func() {
+		result <- coll.CollectLogs(context.Background(), newTestLogger(), &corev1.Pod{}, "app", buffer)
+	}()
+
+	// with all collectors being slow, the multiplexer holds back the
+	// stream, so the buffer has to drop lines to read the entire stream
+	<-buffer.done
+	close(unblock)
+
+	if err := <-result; err != nil {
+		t.Fatalf("Failed to collect logs: %v", err)
+	}
+
+	dropped, _, err := buffer.wait()
+	if err != nil {
+		t.Fatalf("Failed to clean up buffer: %v", err)
+	}
+
+	if dropped == 0 {
+		t.Error("Expected lines to be dropped while the multiplexer was blocked.")
+	}
+}
+
+func TestLineBufferStop(t *testing.T) {
+	reader, writer := io.Pipe()
+
+	buffer := newLineBuffer(reader, BufferOptions{Lines: 1})
+
+	// the pump blocks on the second line, as nothing is reading
+	go func() {
+		_, _ = writer.Write([]byte("1\n2\n3\n"))
+	}()
+
+	buffer.stop()
+	reader.Close()
+
+	if _, _, err := buffer.wait(); err != nil {
+		t.Fatalf("Failed to stop buffer: %v", err)
+	}
+}
+
+func TestBufferedLogs(t *testing.T) {
+	initialPods := []corev1.Pod{
+		newPod("default", "a", withContainer("app", running, 0)),
+	}
+
+	streamer := &fakeLogStreamer{logs: map[string]string{
+		"default/a/app": "hello\nworld\n",
+	}}
+	coll := &fakeCollector{}
+
+	w := NewWatcher(streamer, coll, newTestLogger(), initialPods, nil, nil, Options{
+		OneShot: true,
+		Buffer:  BufferOptions{Lines: 1, Policy: BufferSpill, SpillDirectory: t.TempDir()},
+	})
+
+	result := w.Watch(context.Background(), nil, nil, nil)
+
+	assertStrings(t, "logs", []string{"default/a/app#0: hello\nworld\n"}, coll.sortedLogs())
+
+	if result.DroppedLines != 0 {
+		t.Errorf("Expected no dropped lines, got %d.", result.DroppedLines)
+	}
+}